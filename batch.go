@@ -0,0 +1,95 @@
+package bitcask
+
+import (
+	"github.com/Eslam-Nawara/bitcask/internal/datastore"
+)
+
+type (
+	// Batch groups a set of writes that are applied atomically by Write.
+	// Either all the writes of the batch survive a crash or none of them does.
+	Batch struct {
+		ops   []batchOp
+		index map[string]int
+	}
+
+	// batchOp represents a single write of a batch.
+	batchOp struct {
+		key      []byte
+		value    []byte
+		expiry   int64
+		isDelete bool
+	}
+)
+
+// NewBatch creates an empty write batch.
+func NewBatch() *Batch {
+	return &Batch{
+		index: make(map[string]int),
+	}
+}
+
+// Put adds storing the given key and value to the batch.
+func (batch *Batch) Put(key, value string) {
+	batch.add(batchOp{key: []byte(key), value: []byte(value)})
+}
+
+// PutBytes is the binary-safe version of Put.
+func (batch *Batch) PutBytes(key, value []byte) {
+	batch.add(batchOp{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+}
+
+// Delete adds removing the given key to the batch.
+func (batch *Batch) Delete(key string) {
+	batch.add(batchOp{key: []byte(key), isDelete: true})
+}
+
+// DeleteBytes is the binary-safe version of Delete.
+func (batch *Batch) DeleteBytes(key []byte) {
+	batch.add(batchOp{key: append([]byte{}, key...), isDelete: true})
+}
+
+// Len returns the number of writes in the batch.
+func (batch *Batch) Len() int {
+	return len(batch.ops)
+}
+
+// Reset empties the batch so it can be reused.
+func (batch *Batch) Reset() {
+	batch.ops = batch.ops[:0]
+	batch.index = make(map[string]int)
+}
+
+// add adds the given write to the batch, it replaces any earlier write of the same key.
+func (batch *Batch) add(op batchOp) {
+	if batch.index == nil {
+		batch.index = make(map[string]int)
+	}
+
+	if i, ok := batch.index[string(op.key)]; ok {
+		batch.ops[i] = op
+		return
+	}
+	batch.index[string(op.key)] = len(batch.ops)
+	batch.ops = append(batch.ops, op)
+}
+
+// lookup returns the write of the given key in the batch if exists.
+func (batch *Batch) lookup(key []byte) (batchOp, bool) {
+	i, ok := batch.index[string(key)]
+	if !ok {
+		return batchOp{}, false
+	}
+
+	return batch.ops[i], true
+}
+
+// records returns the data file records of the given writes to the given bucket.
+func records(ops []batchOp, bucketId uint32) []datastore.BatchRec {
+	recs := make([]datastore.BatchRec, len(ops))
+	for i, op := range ops {
+		recs[i] = datastore.BatchRec{BucketId: bucketId, Key: op.key, Value: op.value, Expiry: op.expiry,
+			IsDelete: op.isDelete}
+	}
+
+	return recs
+}
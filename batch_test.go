@@ -0,0 +1,150 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+func TestBatchMixedWrites(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := bc.Put(key, "old "+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	batch := NewBatch()
+	batch.Put("a", "new a")
+	batch.Delete("b")
+	batch.Put("d", "new d")
+	batch.Delete("d")
+	batch.Delete("e")
+	batch.Put("e", "new e")
+	if err := bc.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "new a", "c": "old c", "e": "new e"}
+	checkContents(t, bc, want)
+	bc.Close()
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkContents(t, bc, want)
+}
+
+// writeCutBatch writes some keys then a batch to a new datastore, and cuts the data file
+// at the given number of bytes before its end.
+// Returns the datastore directory and the keys written before the batch.
+func writeCutBatch(t *testing.T, cut int) (string, map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	bc := openStore(t, dir)
+	want := map[string]string{"a": "1", "b": "2"}
+	for key, value := range want {
+		if err := bc.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch := NewBatch()
+	batch.Put("a", "batch a")
+	batch.Delete("b")
+	batch.Put("c", "batch c")
+	if err := bc.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	files := listFiles(t, dir, ".data")
+	if len(files) != 1 {
+		t.Fatalf("got data files %v, want a single one", files)
+	}
+	name := path.Join(dir, files[0])
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(name, info.Size()-int64(cut)); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, want
+}
+
+func TestBatchCutCommitRecord(t *testing.T) {
+	// the commit record is the header of a record without key or value.
+	for _, cut := range []int{recfmt.DataFileHdrSize, 1, recfmt.DataFileHdrSize + 10} {
+		t.Run(fmt.Sprintf("cut %d", cut), func(t *testing.T) {
+			dir, want := writeCutBatch(t, cut)
+
+			bc := openStore(t, dir)
+			checkContents(t, bc, want)
+			if _, err := bc.Get("c"); err == nil {
+				t.Fatal("a key of the uncommitted batch is visible")
+			}
+			if err := bc.Put("d", "4"); err != nil {
+				t.Fatal(err)
+			}
+			want["d"] = "4"
+			bc.Close()
+
+			bc = openStore(t, dir)
+			defer bc.Close()
+			checkContents(t, bc, want)
+		})
+	}
+}
+
+func TestBatchRotation(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir, WithMaxFileSize(1024))
+	want := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		key, value := fmt.Sprintf("key%d", i), strings.Repeat("v", 100)
+		if err := bc.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = value
+	}
+	before := listFiles(t, dir, ".data")
+
+	// the batch does not fit in the rest of the active file, so it goes whole to a new one.
+	batch := NewBatch()
+	for i := 3; i < 8; i++ {
+		key, value := fmt.Sprintf("key%d", i), strings.Repeat("b", 100)
+		batch.Put(key, value)
+		want[key] = value
+	}
+	batch.Delete("key0")
+	delete(want, "key0")
+	if err := bc.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, bc, want)
+	bc.Close()
+
+	after := listFiles(t, dir, ".data")
+	if len(after) != len(before)+1 {
+		t.Fatalf("got data files %v after the batch, want one more than %v", after, before)
+	}
+
+	bc = openStore(t, dir, WithMaxFileSize(1024))
+	defer bc.Close()
+	checkContents(t, bc, want)
+
+	// a batch larger than a whole data file is rejected.
+	batch.Reset()
+	for i := 0; i < 20; i++ {
+		batch.Put(fmt.Sprintf("large%d", i), strings.Repeat("l", 100))
+	}
+	if err := bc.Write(batch); err == nil {
+		t.Fatal("Write of a batch larger than a data file succeeded")
+	}
+	checkContents(t, bc, want)
+}
@@ -3,9 +3,8 @@ package bitcask
 import (
 	"errors"
 	"fmt"
-	"strings"
+	"os"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/Eslam-Nawara/bitcask/internal/datastore"
@@ -35,183 +34,295 @@ type (
 	options struct {
 		syncOption       ConfigOpt
 		accessPermission ConfigOpt
+		// syncSet and accessSet record whether the sync policy and the access permission are given,
+		// so that conflicting values are rejected rather than the last one winning.
+		syncSet           bool
+		accessSet         bool
+		maxFileSize       int64
+		fileMode          os.FileMode
+		dirMode           os.FileMode
+		keyDirType        keydir.KeyDirType
+		compressThreshold int
+		keyProvider       KeyProvider
+		mergePolicy       *MergePolicy
 	}
 
 	// Bitcask represents the bitcask object.
 	// Bitcask contains the metadata needed to manipulate the bitcask datastore.
 	// User creates an object of it to use the bitcask.
 	// Provides several methods to manipulate the datastore data.
+	// The keys accessed through a Bitcask belong to its bucket, Open returns the default bucket
+	// and Bucket returns the handles of the other buckets sharing the same datastore.
 	Bitcask struct {
-		keyDir     keydir.KeyDir
-		usrOpts    options
-		accessMu   sync.Mutex
-		readerCnt  int32
-		dataStore  *datastore.DataStore
-		activeFile *datastore.AppendFile
-		fileFlags  int
+		*store
+		*bucket
+	}
+
+	// store contains the state shared by all the buckets of the datastore.
+	store struct {
+		usrOpts        options
+		accessMu       sync.RWMutex
+		lastTStamp     int64
+		dataStore      *datastore.DataStore
+		activeFile     *datastore.AppendFile
+		fileFlags      int
+		refsMu         sync.Mutex
+		fileRefs       map[string]int
+		pendingDeletes map[string]bool
+		watchMu        sync.Mutex
+		buckets        map[string]*bucket
+		lastBucketId   uint32
+		encrypter      *recfmt.Encrypter
+		fileStats      map[string]*fileStats
+		merger         *merger
+		mergeMu        sync.Mutex
+		recovery       RecoveryReport
+	}
+
+	// mergeEntry holds a record rewritten by Merge, along with the keydir it belongs to.
+	mergeEntry struct {
+		bucket   *bucket
+		reserved bool
+		key      string
+		rec      recfmt.KeyDirRec
+		newRec   recfmt.KeyDirRec
+		drop     bool
 	}
 )
 
-func Open(dataStorePath string, opts ...ConfigOpt) (*Bitcask, error) {
-	bitcask := &Bitcask{}
-	bitcask.usrOpts = parseUsrOpts(opts)
+// Open opens the datastore at the given path with the given options, creating it if not exists.
+// The damaged data files found while opening it are repaired if it is opened with write permission,
+// the report of the damaged files is returned by RecoveryReport, or by OpenWithReport along with the datastore.
+func Open(dataStorePath string, opts ...Option) (*Bitcask, error) {
+	usrOpts, err := parseUsrOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+	if usrOpts.mergePolicy != nil && usrOpts.accessPermission == ReadOnly {
+		return nil, fmt.Errorf("merge policy: %s", errRequireWrite)
+	}
+
+	bitcask := &Bitcask{
+		store: &store{
+			fileRefs:       make(map[string]int),
+			pendingDeletes: make(map[string]bool),
+			buckets:        make(map[string]*bucket),
+			fileStats:      make(map[string]*fileStats),
+		},
+	}
+	bitcask.usrOpts = usrOpts
+
+	bitcask.encrypter, err = recfmt.NewEncrypter(usrOpts.keyProvider)
+	if err != nil {
+		return nil, err
+	}
 
 	privacy, lockMode := bitcask.setPermessions(dataStorePath)
 
-	dataStore, err := datastore.NewDataStore(dataStorePath, lockMode)
+	dataStore, err := datastore.NewDataStore(dataStorePath, lockMode, bitcask.dataStoreConfig())
 	if err != nil {
 		return nil, err
 	}
 
-	keyDir, err := keydir.NewKeyDir(dataStorePath, privacy)
+	keyDirs, files, err := keydir.NewKeyDir(dataStorePath, bitcask.usrOpts.keyDirType, privacy, bitcask.usrOpts.fileMode,
+		bitcask.encrypter)
 	if err != nil {
+		dataStore.Close()
 		return nil, err
 	}
 
 	bitcask.dataStore = dataStore
-	bitcask.keyDir = keyDir
+	err = bitcask.recoverFiles(files)
+	if err != nil {
+		dataStore.Close()
+		return nil, err
+	}
+	err = bitcask.loadBuckets(keyDirs)
+	if err != nil {
+		dataStore.Close()
+		return nil, err
+	}
+	bitcask.loadStats(files)
+
+	if usrOpts.mergePolicy != nil {
+		bitcask.startMerger(*usrOpts.mergePolicy)
+	}
 
 	return bitcask, nil
 }
 
 func (bitcask *Bitcask) Get(key string) (string, error) {
-	var value string
-	var err error
-
-	if bitcask.readerCnt == 0 {
-		bitcask.accessMu.Lock()
-	}
-	atomic.AddInt32(&bitcask.readerCnt, 1)
+	value, err := bitcask.GetBytes([]byte(key))
+	return string(value), err
+}
 
-	rec, isExist := bitcask.keyDir[key]
-	if !isExist {
-		value = ""
-		err = fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
-	} else {
-		value, err = bitcask.dataStore.ReadValueFromFile(rec.FileId, key, rec.ValuePos, rec.ValueSize)
-	}
-
-	atomic.AddInt32(&bitcask.readerCnt, -1)
-	if bitcask.readerCnt == 0 {
-		bitcask.accessMu.Unlock()
-	}
+// GetBytes is the binary-safe version of Get.
+// It reads the value of the given key without any string conversions.
+func (bitcask *Bitcask) GetBytes(key []byte) ([]byte, error) {
+	bitcask.accessMu.RLock()
+	defer bitcask.accessMu.RUnlock()
 
+	value, _, err := bitcask.get(key)
 	return value, err
 }
 
 func (bitcask *Bitcask) Put(key, value string) error {
+	return bitcask.PutBytes([]byte(key), []byte(value))
+}
+
+// PutBytes is the binary-safe version of Put.
+// It stores the given key and value without any string conversions.
+func (bitcask *Bitcask) PutBytes(key, value []byte) error {
 	if bitcask.usrOpts.accessPermission == ReadOnly {
 		return fmt.Errorf("Put: %s", errRequireWrite)
 	}
-	tStamp := time.Now().UnixMicro()
-
-	bitcask.accessMu.Lock()
-	defer bitcask.accessMu.Unlock()
-
-	n, err := bitcask.activeFile.WriteData(key, value, tStamp)
+	err := checkKey(key)
 	if err != nil {
 		return err
 	}
 
-	bitcask.keyDir[key] = recfmt.KeyDirRec{
-		FileId:    bitcask.activeFile.Name(),
-		ValuePos:  uint32(n),
-		ValueSize: uint32(len(value)),
-		TStamp:    tStamp,
-	}
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
 
-	return nil
+	return bitcask.put(key, value, 0)
 }
 
 func (bitcask *Bitcask) Delete(key string) error {
+	return bitcask.DeleteBytes([]byte(key))
+}
+
+// DeleteBytes is the binary-safe version of Delete.
+func (bitcask *Bitcask) DeleteBytes(key []byte) error {
 	if bitcask.usrOpts.accessPermission == ReadOnly {
 		return fmt.Errorf("Delete: %s", errRequireWrite)
 	}
 
-	_, err := bitcask.Get(key)
-	if err != nil {
-		return err
-	}
-
-	bitcask.Put(key, datastore.TompStone)
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
 
-	return nil
+	return bitcask.delete(key)
 }
 
 func (bitcask *Bitcask) ListKeys() []string {
 	res := make([]string, 0)
 
-	if bitcask.readerCnt == 0 {
-		bitcask.accessMu.Lock()
-	}
-	atomic.AddInt32(&bitcask.readerCnt, 1)
+	bitcask.accessMu.RLock()
+	defer bitcask.accessMu.RUnlock()
 
-	for key := range bitcask.keyDir {
-		res = append(res, key)
-	}
+	now := time.Now().UnixMicro()
+	bitcask.keyDir.Range(func(key string, rec recfmt.KeyDirRec) bool {
+		if !rec.IsExpired(now) {
+			res = append(res, key)
+		}
+		return true
+	})
 
-	atomic.AddInt32(&bitcask.readerCnt, -1)
-	if bitcask.readerCnt == 0 {
-		bitcask.accessMu.Unlock()
+	return res
+}
+
+// ListKeysBytes is the binary-safe version of ListKeys.
+func (bitcask *Bitcask) ListKeysBytes() [][]byte {
+	keys := bitcask.ListKeys()
+
+	res := make([][]byte, len(keys))
+	for i, key := range keys {
+		res[i] = []byte(key)
 	}
 
 	return res
 }
 
 func (bitcask *Bitcask) Fold(fn func(string, string, any) any, acc any) any {
-	if bitcask.readerCnt == 0 {
-		bitcask.accessMu.Lock()
-	}
-	atomic.AddInt32(&bitcask.readerCnt, 1)
+	return bitcask.FoldBytes(func(key, value []byte, acc any) any {
+		return fn(string(key), string(value), acc)
+	}, acc)
+}
 
-	for key := range bitcask.keyDir {
-		value, _ := bitcask.Get(key)
-		acc = fn(key, value, acc)
-	}
+// FoldBytes is the binary-safe version of Fold.
+// It folds over a snapshot of the datastore and stops at the first failed read, use Iterator to get the error.
+func (bitcask *Bitcask) FoldBytes(fn func([]byte, []byte, any) any, acc any) any {
+	it := bitcask.Iterator()
+	defer it.Close()
 
-	atomic.AddInt32(&bitcask.readerCnt, -1)
-	if bitcask.readerCnt == 0 {
-		bitcask.accessMu.Unlock()
+	return fold(it, fn, acc)
+}
+
+// Write applies all the writes of the given batch atomically.
+// The batch records are appended along with a commit marker in a single write,
+// so a batch interrupted by a crash is skipped when the datastore is opened again.
+func (bitcask *Bitcask) Write(batch *Batch) error {
+	if bitcask.usrOpts.accessPermission == ReadOnly {
+		return fmt.Errorf("Write: %s", errRequireWrite)
+	}
+	if batch.Len() == 0 {
+		return nil
 	}
-	return acc
+	for _, op := range batch.ops {
+		err := checkKey(op.key)
+		if err != nil {
+			return err
+		}
+	}
+
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
+
+	return bitcask.write(batch.ops)
 }
 
+// Merge rewrites the live records of the files other than the active file to new files along with their hint files,
+// then deletes the old files.
+// The records are rewritten without blocking the reads and writes of the datastore,
+// which is only locked to list the records and to point the keys to the rewritten records.
+// The keys written or deleted during the merge keep their newer records.
+// The merge is committed atomically once the new files are written, a merge interrupted by a crash
+// is rolled back or completed by the next Open with write permission.
 func (bitcask *Bitcask) Merge() error {
 	if bitcask.usrOpts.accessPermission == ReadOnly {
 		return fmt.Errorf("Merge: %s", errRequireWrite)
 	}
 
+	bitcask.mergeMu.Lock()
+	defer bitcask.mergeMu.Unlock()
+
+	bitcask.accessMu.Lock()
 	oldFiles, err := bitcask.listOldFiles()
 	if err != nil {
+		bitcask.accessMu.Unlock()
 		return err
 	}
+	entries := bitcask.mergeEntries()
+	bitcask.accessMu.Unlock()
 
-	bitcask.accessMu.Lock()
-	newKeyDir := keydir.KeyDir{}
-	mergeFile := datastore.NewAppendFile(bitcask.dataStore.Path(), bitcask.fileFlags, datastore.Merge)
-	defer mergeFile.Close()
-
-	for key, rec := range bitcask.keyDir {
-		if rec.FileId != bitcask.activeFile.Name() {
-			newRec, err := bitcask.mergeWrite(mergeFile, key)
-			if err != nil {
-				if !strings.HasSuffix(err.Error(), datastore.ErrKeyNotExist.Error()) {
-					bitcask.accessMu.Unlock()
-					return err
-				}
-			} else {
-				newKeyDir[key] = newRec
-			}
-		} else {
-			newKeyDir[key] = rec
-		}
+	mergeFile := datastore.NewAppendFile(bitcask.dataStore.Path(), bitcask.fileFlags, datastore.Merge,
+		bitcask.dataStore.Config())
+	mergedFiles, err := bitcask.mergeWrite(mergeFile, entries)
+	mergeFile.Close()
+	if err != nil {
+		bitcask.dataStore.RollbackMerge()
+		return err
 	}
 
-	bitcask.keyDir = newKeyDir
+	fileNames := make([]string, 0, len(mergedFiles))
+	for file := range mergedFiles {
+		fileNames = append(fileNames, file)
+	}
+	err = bitcask.dataStore.CommitMerge(fileNames, oldFiles)
+	if err != nil {
+		return err
+	}
+
+	bitcask.accessMu.Lock()
+	bitcask.swapMerged(entries)
+	for file, size := range mergedFiles {
+		bitcask.statsOf(file).total = size
+	}
+	for _, file := range oldFiles {
+		delete(bitcask.fileStats, file)
+	}
 	bitcask.accessMu.Unlock()
-	bitcask.deleteOldFiles(oldFiles)
 
-	return nil
+	return bitcask.deleteOldFiles(oldFiles)
 }
 
 func (bitcask *Bitcask) Sync() error {
@@ -223,9 +334,17 @@ func (bitcask *Bitcask) Sync() error {
 }
 
 func (bitcask *Bitcask) Close() {
+	bitcask.stopMerger()
+	bitcask.mergeMu.Lock()
+	defer bitcask.mergeMu.Unlock()
+
+	for _, bucket := range bitcask.buckets {
+		bitcask.handle(bucket).cancelWatchers()
+	}
 	if bitcask.usrOpts.accessPermission == ReadWrite {
 		bitcask.Sync()
 		bitcask.activeFile.Close()
+		bitcask.deletePendingFiles()
 	}
 	bitcask.dataStore.Close()
 }
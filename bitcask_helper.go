@@ -1,8 +1,10 @@
 package bitcask
 
 import (
+	"fmt"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/Eslam-Nawara/bitcask/internal/datastore"
@@ -10,22 +12,36 @@ import (
 	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
 )
 
-func parseUsrOpts(opts []ConfigOpt) options {
+// parseUsrOpts applies the given options over the default ones.
+// returns an error if any of the options is invalid.
+func parseUsrOpts(opts []Option) (options, error) {
 	usrOpts := options{
 		syncOption:       SyncOnDemand,
 		accessPermission: ReadOnly,
+		maxFileSize:      datastore.DefaultMaxFileSize,
+		fileMode:         datastore.DefaultFileMode,
+		dirMode:          datastore.DefaultDirMode,
 	}
 
 	for _, opt := range opts {
-		switch opt {
-		case SyncOnPut:
-			usrOpts.syncOption = SyncOnPut
-		case ReadWrite:
-			usrOpts.accessPermission = ReadWrite
+		err := opt.apply(&usrOpts)
+		if err != nil {
+			return options{}, err
 		}
 	}
 
-	return usrOpts
+	return usrOpts, nil
+}
+
+// dataStoreConfig returns the datastore config specified by the user options.
+func (bitcask *Bitcask) dataStoreConfig() datastore.Config {
+	return datastore.Config{
+		MaxFileSize:       bitcask.usrOpts.maxFileSize,
+		FileMode:          bitcask.usrOpts.fileMode,
+		DirMode:           bitcask.usrOpts.dirMode,
+		CompressThreshold: bitcask.usrOpts.compressThreshold,
+		Encrypter:         bitcask.encrypter,
+	}
 }
 
 func (bitcask *Bitcask) setPermessions(dataStorePath string) (keydir.KeyDirPrivacy, datastore.LockMode) {
@@ -40,7 +56,8 @@ func (bitcask *Bitcask) setPermessions(dataStorePath string) (keydir.KeyDirPriva
 			fileFlags |= os.O_SYNC
 		}
 		bitcask.fileFlags = fileFlags
-		bitcask.activeFile = datastore.NewAppendFile(dataStorePath, bitcask.fileFlags, datastore.Active)
+		bitcask.activeFile = datastore.NewAppendFile(dataStorePath, bitcask.fileFlags, datastore.Active,
+			bitcask.dataStoreConfig())
 	} else {
 		privacy = keydir.SharedKeyDir
 		lockMode = datastore.SharedLock
@@ -49,6 +66,143 @@ func (bitcask *Bitcask) setPermessions(dataStorePath string) (keydir.KeyDirPriva
 	return privacy, lockMode
 }
 
+// get reads the value of the given key along with its keydir record timestamp.
+// the timestamp is zero if the key does not exist.
+// the caller must hold the access lock.
+func (bitcask *Bitcask) get(key []byte) ([]byte, int64, error) {
+	rec, isExist := bitcask.keyDir.Get(string(key))
+	if !isExist {
+		return nil, 0, fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+	}
+	if rec.IsExpired(time.Now().UnixMicro()) {
+		return nil, rec.TStamp, fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+	}
+
+	value, err := bitcask.dataStore.ReadValueFromFile(rec.FileId, key, rec.ValuePos, rec.ValueSize)
+	return value, rec.TStamp, err
+}
+
+// put appends a record of the given key and value to the active file and updates the keydir.
+// expiry is the time in unix microseconds after which the record expires, zero means it never expires.
+// the caller must hold the access lock for writing.
+func (bitcask *Bitcask) put(key, value []byte, expiry int64) error {
+	if bitcask.dropped {
+		return fmt.Errorf("%s: %s", bitcask.name, errBucketNotExist)
+	}
+	err := bitcask.checkIndexes()
+	if err != nil {
+		return err
+	}
+	if len(bitcask.indexes) > 0 {
+		return bitcask.write([]batchOp{{key: key, value: value, expiry: expiry}})
+	}
+
+	tStamp := bitcask.nextTStamp()
+	rec, err := bitcask.activeFile.WriteData(bitcask.id, key, value, tStamp, expiry)
+	if err != nil {
+		return err
+	}
+
+	bitcask.putRec(bitcask.keyDir, string(key), rec)
+	bitcask.appended(bitcask.activeFile, 0)
+	bitcask.notify([]batchOp{{key: key, value: value}}, tStamp)
+
+	return nil
+}
+
+// delete appends a tombstone of the given key to the active file and removes it from the keydir.
+// the caller must hold the access lock for writing.
+func (bitcask *Bitcask) delete(key []byte) error {
+	if !bitcask.exists(key) {
+		return fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+	}
+	err := bitcask.checkIndexes()
+	if err != nil {
+		return err
+	}
+	if len(bitcask.indexes) > 0 {
+		return bitcask.write([]batchOp{{key: key, isDelete: true}})
+	}
+
+	tStamp := bitcask.nextTStamp()
+	err = bitcask.activeFile.WriteTompStone(bitcask.id, key, tStamp)
+	if err != nil {
+		return err
+	}
+	bitcask.deleteRec(bitcask.keyDir, string(key))
+	bitcask.appended(bitcask.activeFile, 1)
+	bitcask.notify([]batchOp{{key: key, isDelete: true}}, tStamp)
+
+	return nil
+}
+
+// exists reports whether the given key exists and has not expired.
+// the caller must hold the access lock.
+func (bitcask *Bitcask) exists(key []byte) bool {
+	rec, isExist := bitcask.keyDir.Get(string(key))
+	return isExist && !rec.IsExpired(time.Now().UnixMicro())
+}
+
+// write appends the records of the given writes as a single batch to the active file and updates the keydir.
+// the index entries of the writes are updated in the same batch.
+// the caller must hold the access lock for writing.
+func (bitcask *Bitcask) write(ops []batchOp) error {
+	if bitcask.dropped {
+		return fmt.Errorf("%s: %s", bitcask.name, errBucketNotExist)
+	}
+	if hasUserKeys(ops) {
+		err := bitcask.checkIndexes()
+		if err != nil {
+			return err
+		}
+	}
+	if len(bitcask.indexes) > 0 {
+		indexOps, err := bitcask.indexOps(ops)
+		if err != nil {
+			return err
+		}
+		ops = append(ops[:len(ops):len(ops)], indexOps...)
+	}
+
+	tStamp := bitcask.nextTStamp()
+
+	recs := records(ops, bitcask.id)
+	keyDirRecs, err := bitcask.activeFile.WriteBatch(recs, tStamp)
+	if err != nil {
+		return err
+	}
+
+	tompStones := 0
+	for i, op := range ops {
+		keyDir := bitcask.keyDirOf(op.key)
+		if op.isDelete {
+			bitcask.deleteRec(keyDir, string(op.key))
+			tompStones++
+			continue
+		}
+		bitcask.putRec(keyDir, string(op.key), keyDirRecs[i])
+	}
+	bitcask.appended(bitcask.activeFile, tompStones)
+	bitcask.notify(ops, tStamp)
+
+	return nil
+}
+
+// nextTStamp returns the timestamp of the next write.
+// timestamps are strictly increasing so that every write of a key is distinguishable by its timestamp.
+// the caller must hold the access lock for writing.
+func (bitcask *Bitcask) nextTStamp() int64 {
+	tStamp := time.Now().UnixMicro()
+	if tStamp <= bitcask.lastTStamp {
+		tStamp = bitcask.lastTStamp + 1
+	}
+	bitcask.lastTStamp = tStamp
+
+	return tStamp
+}
+
+// listOldFiles lists the files of the datastore other than the active file, which are rewritten by Merge.
+// the caller must hold the access lock, so that the active file does not change meanwhile.
 func (bitcask *Bitcask) listOldFiles() ([]string, error) {
 	oldFiles := make([]string, 0)
 
@@ -58,16 +212,15 @@ func (bitcask *Bitcask) listOldFiles() ([]string, error) {
 	}
 	defer dataStore.Close()
 
-	bitcask.accessMu.Lock()
 	files, err := dataStore.Readdir(0)
-	bitcask.accessMu.Unlock()
 	if err != nil {
 		return nil, err
 	}
 
 	for _, file := range files {
 		fileName := file.Name()
-		if fileName[0] != '.' && fileName != bitcask.activeFile.Name() && fileName != "keydir" {
+		isDataFile := strings.HasSuffix(fileName, ".data") || strings.HasSuffix(fileName, ".hint")
+		if isDataFile && fileName != bitcask.activeFile.Name() {
 			oldFiles = append(oldFiles, fileName)
 		}
 	}
@@ -75,46 +228,169 @@ func (bitcask *Bitcask) listOldFiles() ([]string, error) {
 	return oldFiles, nil
 }
 
-// mergeWrite performs a writing to the created merge file.
-// returns the new record about the written data
-// returns error if the data is deleted and will not be written again or on any system failures.
-func (bitcask *Bitcask) mergeWrite(mergeFile *datastore.AppendFile, key string) (recfmt.KeyDirRec, error) {
-	rec := bitcask.keyDir[key]
+// mergeEntries returns the records of all the buckets that are not in the active file.
+// the expired records and the reserved records that are no longer needed are marked to be dropped.
+// the caller must hold the access lock.
+func (bitcask *Bitcask) mergeEntries() []mergeEntry {
+	entries := make([]mergeEntry, 0)
+	now := time.Now().UnixMicro()
 
-	value, err := bitcask.dataStore.ReadValueFromFile(rec.FileId, key, rec.ValuePos, rec.ValueSize)
-	if err != nil {
-		return recfmt.KeyDirRec{}, err
-	}
+	for _, bucket := range bitcask.buckets {
+		handle := bitcask.handle(bucket)
+		for _, reserved := range []bool{false, true} {
+			keyDir := bucket.keyDir
+			if reserved {
+				keyDir = bucket.reservedKeyDir
+			}
 
-	tStamp := time.Now().UnixMicro()
+			keyDir.Range(func(key string, rec recfmt.KeyDirRec) bool {
+				if rec.FileId == bitcask.activeFile.Name() {
+					return true
+				}
 
-	n, err := mergeFile.WriteData(key, value, tStamp)
-	if err != nil {
-		return recfmt.KeyDirRec{}, err
+				drop := rec.IsExpired(now) || (reserved && !handle.isLiveReservedKey(key))
+				entries = append(entries, mergeEntry{bucket: bucket, reserved: reserved, key: key, rec: rec, drop: drop})
+				return true
+			})
+		}
 	}
 
-	newRec := recfmt.KeyDirRec{
-		FileId:    mergeFile.Name(),
-		ValuePos:  uint32(n),
-		ValueSize: uint32(len(value)),
-		TStamp:    tStamp,
+	return entries
+}
+
+// mergeWrite rewrites the records of the given entries that are not dropped to the merge file,
+// and sets the entries to the merged records.
+// the old files are immutable, so the records are read and rewritten without holding the access lock.
+// returns the sizes of the written merge files mapped by their names.
+func (bitcask *Bitcask) mergeWrite(mergeFile *datastore.AppendFile, entries []mergeEntry) (map[string]int64, error) {
+	mergedFiles := make(map[string]int64)
+
+	for i := range entries {
+		entry := &entries[i]
+		if entry.drop {
+			continue
+		}
+
+		key, rec := []byte(entry.key), entry.rec
+		value, err := bitcask.dataStore.ReadValueFromFile(rec.FileId, key, rec.ValuePos, rec.ValueSize)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.newRec, err = mergeFile.WriteData(rec.BucketId, key, value, rec.TStamp, rec.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		err = mergeFile.WriteHint(entry.key, entry.newRec)
+		if err != nil {
+			return nil, err
+		}
+		mergedFiles[mergeFile.Name()] = mergeFile.Size()
 	}
 
-	err = mergeFile.WriteHint(key, newRec)
-	if err != nil {
-		return recfmt.KeyDirRec{}, err
+	return mergedFiles, nil
+}
+
+// swapMerged points the keys of the given entries to their merged records, or removes the dropped keys.
+// a key is only updated if it still points to the record that was merged,
+// the keys written or deleted during the merge keep their newer records.
+// the caller must hold the access lock for writing.
+func (bitcask *Bitcask) swapMerged(entries []mergeEntry) {
+	for _, entry := range entries {
+		if entry.bucket.dropped {
+			continue
+		}
+
+		keyDir := entry.bucket.keyDir
+		if entry.reserved {
+			keyDir = entry.bucket.reservedKeyDir
+		}
+
+		rec, ok := keyDir.Get(entry.key)
+		if !ok || rec.FileId != entry.rec.FileId || rec.ValuePos != entry.rec.ValuePos {
+			continue
+		}
+		if entry.drop {
+			bitcask.deleteRec(keyDir, entry.key)
+		} else {
+			bitcask.putRec(keyDir, entry.key, entry.newRec)
+		}
 	}
-	return newRec, nil
 }
 
 // deleteOldFiles deletes all files passed to it.
+// the files still referenced by a live snapshot are deleted once the last snapshot referencing them is closed.
 func (bitcask *Bitcask) deleteOldFiles(files []string) error {
+	bitcask.refsMu.Lock()
+	defer bitcask.refsMu.Unlock()
+
 	for _, file := range files {
-		err := os.Remove(path.Join(bitcask.dataStore.Path(), file))
+		if bitcask.fileRefs[file] > 0 {
+			bitcask.pendingDeletes[file] = true
+			continue
+		}
+
+		err := bitcask.removeFile(file)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return bitcask.finishMerge()
+}
+
+// finishMerge removes the manifest of the committed merge once none of its old files are pending deletion.
+// the caller must hold the refs lock.
+func (bitcask *Bitcask) finishMerge() error {
+	if len(bitcask.pendingDeletes) > 0 {
+		return nil
+	}
+
+	return bitcask.dataStore.FinishMerge()
+}
+
+// retainFile keeps the given data file from being deleted by Merge until it is released.
+func (bitcask *Bitcask) retainFile(fileId string) {
+	bitcask.refsMu.Lock()
+	defer bitcask.refsMu.Unlock()
+
+	bitcask.fileRefs[fileId]++
+}
+
+// releaseFile releases the given data file, it is deleted if it has been merged and no longer referenced.
+func (bitcask *Bitcask) releaseFile(fileId string) {
+	bitcask.refsMu.Lock()
+	defer bitcask.refsMu.Unlock()
+
+	bitcask.fileRefs[fileId]--
+	if bitcask.fileRefs[fileId] == 0 {
+		delete(bitcask.fileRefs, fileId)
+		if bitcask.pendingDeletes[fileId] {
+			delete(bitcask.pendingDeletes, fileId)
+			bitcask.removeFile(fileId)
+			bitcask.finishMerge()
+		}
+	}
+}
+
+// deletePendingFiles deletes the merged files that are still referenced by live snapshots.
+func (bitcask *Bitcask) deletePendingFiles() {
+	bitcask.refsMu.Lock()
+	defer bitcask.refsMu.Unlock()
+
+	for file := range bitcask.pendingDeletes {
+		bitcask.removeFile(file)
+		delete(bitcask.pendingDeletes, file)
+	}
+	bitcask.finishMerge()
+}
+
+// removeFile removes the given file from the datastore directory, a file that is already removed is ignored.
+func (bitcask *Bitcask) removeFile(file string) error {
+	err := os.Remove(path.Join(bitcask.dataStore.Path(), file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
 }
@@ -1 +1,247 @@
 package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// openStore opens the datastore at the given directory for writing.
+func openStore(t *testing.T, dir string, opts ...Option) *Bitcask {
+	t.Helper()
+
+	bc, err := Open(dir, append([]Option{WithReadWrite()}, opts...)...)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+
+	return bc
+}
+
+// fillStore writes, overwrites and deletes keys in small data files, and returns the expected contents.
+func fillStore(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	bc := openStore(t, dir, WithMaxFileSize(2048))
+	want := make(map[string]string)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 50; i++ {
+			key, value := fmt.Sprintf("key%02d", i), fmt.Sprintf("value%02d-%d-%s", i, round, strings.Repeat("x", 40))
+			if err := bc.Put(key, value); err != nil {
+				t.Fatal(err)
+			}
+			want[key] = value
+		}
+	}
+	for i := 0; i < 50; i += 5 {
+		key := fmt.Sprintf("key%02d", i)
+		if err := bc.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+		delete(want, key)
+	}
+	bc.Close()
+
+	return want
+}
+
+// checkContents checks that the given datastore has exactly the given keys and values.
+func checkContents(t *testing.T, bc *Bitcask, want map[string]string) {
+	t.Helper()
+
+	keys := bc.ListKeys()
+	if len(keys) != len(want) {
+		t.Fatalf("got %d keys, want %d", len(keys), len(want))
+	}
+	for key, value := range want {
+		got, err := bc.Get(key)
+		if err != nil {
+			t.Fatalf("Get(%q): %s", key, err)
+		}
+		if got != value {
+			t.Fatalf("Get(%q): got %q, want %q", key, got, value)
+		}
+	}
+}
+
+// listFiles returns the sorted names of the files of the given directory having the given suffix.
+func listFiles(t *testing.T, dir, suffix string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), suffix) {
+			res = append(res, entry.Name())
+		}
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// copyFile copies the given file to the given destination.
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// copyDir copies the regular files of the given directory to a new temporary directory.
+func copyDir(t *testing.T, src string) string {
+	t.Helper()
+
+	dst := t.TempDir()
+	for _, name := range listFiles(t, src, "") {
+		copyFile(t, path.Join(src, name), path.Join(dst, name))
+	}
+
+	return dst
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// binaryPairs holds keys and values with zero bytes and invalid UTF-8.
+var binaryPairs = [][2][]byte{
+	{[]byte("a\x00b"), []byte("\x00\x00value\x00")},
+	{[]byte{0xff, 0xfe, 0x00, 0x80}, []byte{0xc3, 0x28, 0x00, 0xff}},
+	{[]byte("\x00"), []byte{}},
+	{[]byte("plain"), []byte("\xed\xa0\x80")},
+}
+
+// checkBinaryPairs checks that every pair of binaryPairs is stored as written.
+func checkBinaryPairs(t *testing.T, bc *Bitcask) {
+	t.Helper()
+
+	for _, pair := range binaryPairs {
+		value, err := bc.GetBytes(pair[0])
+		if err != nil {
+			t.Fatalf("GetBytes(%q): %s", pair[0], err)
+		}
+		if !bytes.Equal(value, pair[1]) {
+			t.Fatalf("GetBytes(%q): got %q, want %q", pair[0], value, pair[1])
+		}
+	}
+
+	keys := bc.ListKeysBytes()
+	if len(keys) != len(binaryPairs) {
+		t.Fatalf("got %d keys, want %d", len(keys), len(binaryPairs))
+	}
+	for _, key := range keys {
+		found := false
+		for _, pair := range binaryPairs {
+			found = found || bytes.Equal(key, pair[0])
+		}
+		if !found {
+			t.Fatalf("unexpected key %q", key)
+		}
+	}
+}
+
+func TestBinaryKeysAndValues(t *testing.T) {
+	dir := t.TempDir()
+
+	bc := openStore(t, dir)
+	for _, pair := range binaryPairs {
+		if err := bc.PutBytes(pair[0], []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.PutBytes(pair[0], pair[1]); err != nil {
+			t.Fatalf("PutBytes(%q): %s", pair[0], err)
+		}
+	}
+	checkBinaryPairs(t, bc)
+	bc.Close()
+
+	bc = openStore(t, dir)
+	checkBinaryPairs(t, bc)
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	checkBinaryPairs(t, bc)
+	bc.Close()
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkBinaryPairs(t, bc)
+}
+
+func TestReservedKeyPrefix(t *testing.T) {
+	bc := openStore(t, t.TempDir())
+	defer bc.Close()
+
+	key := []byte(reservedKeyPrefix + "index:name")
+	if err := bc.PutBytes(key, []byte("value")); err == nil {
+		t.Fatal("PutBytes of a reserved key succeeded")
+	}
+	batch := NewBatch()
+	batch.PutBytes(key, []byte("value"))
+	if err := bc.Write(batch); err == nil {
+		t.Fatal("Write of a batch with a reserved key succeeded")
+	}
+	if err := bc.Update(func(tx *Txn) error { return tx.PutBytes(key, []byte("value")) }); err == nil {
+		t.Fatal("a transaction writing a reserved key succeeded")
+	}
+	if _, err := bc.GetBytes(key); err == nil {
+		t.Fatal("a reserved key is written")
+	}
+
+	// a key only sharing the start of the prefix is a user key.
+	if err := bc.PutBytes([]byte("\x00bitcask"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTStampAfterFutureRecords(t *testing.T) {
+	dir := t.TempDir()
+	// the records were written while the clock was an hour ahead.
+	future := time.Now().Add(time.Hour).UnixMicro()
+	writeFile(t, path.Join(dir, "1000.data"),
+		recfmt.CompressDataFileRec(recfmt.RecPut, 0, 0, []byte("a"), []byte("1"), future, 0),
+		recfmt.CompressDataFileRec(recfmt.RecPut, 0, 0, []byte("b"), []byte("2"), future+1, 0),
+		recfmt.CompressDataFileRec(recfmt.RecDelete, 0, 0, []byte("b"), nil, future+2, 0))
+
+	bc := openStore(t, dir)
+	if err := bc.Put("a", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("b", "new"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "new", "b": "new"}
+	checkContents(t, bc, want)
+	bc.Close()
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkContents(t, bc, want)
+}
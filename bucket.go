@@ -0,0 +1,179 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Eslam-Nawara/bitcask/internal/keydir"
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+const (
+	// bucketDefPrefix prefixes the keys recording the created buckets in the default bucket,
+	// the value of a bucket key is the id of the bucket.
+	bucketDefPrefix = reservedKeyPrefix + "bucket:"
+	// lastBucketKey is the key recording the id of the last created bucket in the default bucket,
+	// so that the ids of the dropped buckets are never reused.
+	lastBucketKey = reservedKeyPrefix + "lastbucket"
+)
+
+var (
+	// errInvalidBucket happens whenever a bucket is given an empty name, which is the name of the default bucket.
+	errInvalidBucket = errors.New("invalid bucket name")
+	// errBucketNotExist happens whenever a bucket is used before it is created or after it is dropped.
+	errBucketNotExist = errors.New("bucket does not exist")
+)
+
+// bucket represents a keyspace of the datastore.
+// the records of a bucket carry its id, so the same key can exist in different buckets.
+type bucket struct {
+	id             uint32
+	name           string
+	dropped        bool
+	keyDir         keydir.KeyDir
+	reservedKeyDir keydir.KeyDir
+	indexes        map[string]IndexExtractor
+	watchers       map[*watcher]bool
+}
+
+// Bucket returns a handle to the bucket with the given name, creating it if not exists.
+// The handle has the full API of Bitcask scoped to the keys of the bucket,
+// except for Sync, Merge and Close which apply to the whole datastore.
+// Bucket names are global, calling Bucket through a bucket handle does not nest the buckets.
+func (bitcask *Bitcask) Bucket(name string) (*Bitcask, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%q: %s", name, errInvalidBucket)
+	}
+
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
+
+	if bucket, ok := bitcask.buckets[name]; ok {
+		return bitcask.handle(bucket), nil
+	}
+	if bitcask.usrOpts.accessPermission == ReadOnly {
+		return nil, fmt.Errorf("%s: %s", name, errBucketNotExist)
+	}
+
+	id := bitcask.lastBucketId + 1
+	ops := []batchOp{
+		{key: []byte(bucketDefPrefix + name), value: binary.LittleEndian.AppendUint32(nil, id)},
+		{key: []byte(lastBucketKey), value: binary.LittleEndian.AppendUint32(nil, id)},
+	}
+	err := bitcask.handle(bitcask.buckets[""]).write(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := newBucket(id, name, keydir.New(bitcask.usrOpts.keyDirType))
+	bitcask.buckets[name] = bucket
+	bitcask.lastBucketId = id
+
+	return bitcask.handle(bucket), nil
+}
+
+// DropBucket removes the bucket with the given name and all of its keys,
+// Merge reclaims the disk space used by them.
+// The handles of the dropped bucket can no longer write and its subscriptions are cancelled.
+func (bitcask *Bitcask) DropBucket(name string) error {
+	if bitcask.usrOpts.accessPermission == ReadOnly {
+		return fmt.Errorf("DropBucket: %s", errRequireWrite)
+	}
+	if name == "" {
+		return fmt.Errorf("%q: %s", name, errInvalidBucket)
+	}
+
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
+
+	bucket, ok := bitcask.buckets[name]
+	if !ok {
+		return fmt.Errorf("%s: %s", name, errBucketNotExist)
+	}
+
+	def := batchOp{key: []byte(bucketDefPrefix + name), isDelete: true}
+	err := bitcask.handle(bitcask.buckets[""]).write([]batchOp{def})
+	if err != nil {
+		return err
+	}
+
+	delete(bitcask.buckets, name)
+	bitcask.dropLive(bucket.keyDir, bucket.reservedKeyDir)
+	bucket.dropped = true
+	bucket.keyDir = bucket.keyDir.Empty()
+	bucket.reservedKeyDir = bucket.reservedKeyDir.Empty()
+	bucket.indexes = make(map[string]IndexExtractor)
+	bitcask.handle(bucket).cancelWatchers()
+
+	return nil
+}
+
+// loadBuckets sets up the default bucket and the created buckets from the given keydirs mapped by the bucket ids.
+// the keydirs of the dropped buckets are discarded.
+func (bitcask *Bitcask) loadBuckets(keyDirs map[uint32]keydir.KeyDir) error {
+	keyDirOf := func(id uint32) keydir.KeyDir {
+		if keyDir, ok := keyDirs[id]; ok {
+			return keyDir
+		}
+		return keydir.New(bitcask.usrOpts.keyDirType)
+	}
+
+	bitcask.bucket = newBucket(0, "", keyDirOf(0))
+	bitcask.buckets[""] = bitcask.bucket
+
+	if rec, ok := bitcask.reservedKeyDir.Get(lastBucketKey); ok {
+		id, err := bitcask.readBucketId(lastBucketKey, rec)
+		if err != nil {
+			return err
+		}
+		bitcask.lastBucketId = id
+	}
+
+	var err error
+	end := prefixEnd([]byte(bucketDefPrefix))
+	bitcask.reservedKeyDir.Ascend(bucketDefPrefix, end, func(key string, rec recfmt.KeyDirRec) bool {
+		id, readErr := bitcask.readBucketId(key, rec)
+		if readErr != nil {
+			err = readErr
+			return false
+		}
+
+		name := strings.TrimPrefix(key, bucketDefPrefix)
+		bitcask.buckets[name] = newBucket(id, name, keyDirOf(id))
+		return true
+	})
+
+	return err
+}
+
+// readBucketId reads the bucket id stored as the value of the given key.
+func (bitcask *Bitcask) readBucketId(key string, rec recfmt.KeyDirRec) (uint32, error) {
+	value, err := bitcask.dataStore.ReadValueFromFile(rec.FileId, []byte(key), rec.ValuePos, rec.ValueSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint32(value), nil
+}
+
+// handle returns a handle to the given bucket of the datastore.
+func (bitcask *Bitcask) handle(bucket *bucket) *Bitcask {
+	return &Bitcask{store: bitcask.store, bucket: bucket}
+}
+
+// newBucket creates a bucket over the given keydir, the reserved keys are moved to the bucket reserved keydir.
+func newBucket(id uint32, name string, keyDir keydir.KeyDir) *bucket {
+	bucket := &bucket{
+		id:             id,
+		name:           name,
+		keyDir:         keyDir,
+		reservedKeyDir: keydir.New(keydir.OrderedKeyDir),
+		indexes:        make(map[string]IndexExtractor),
+		watchers:       make(map[*watcher]bool),
+	}
+	keydir.Split(keyDir, reservedKeyPrefix, prefixEnd([]byte(reservedKeyPrefix)), bucket.reservedKeyDir)
+
+	return bucket
+}
@@ -0,0 +1,120 @@
+package bitcask
+
+import (
+	"testing"
+)
+
+// checkBuckets checks that the default bucket and the given buckets have exactly the given contents.
+func checkBuckets(t *testing.T, bc *Bitcask, want map[string]map[string]string) {
+	t.Helper()
+
+	for name, contents := range want {
+		bucket := bc
+		if name != "" {
+			var err error
+			bucket, err = bc.Bucket(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkContents(t, bucket, contents)
+	}
+}
+
+func TestBucketIsolation(t *testing.T) {
+	dir := t.TempDir()
+	want := map[string]map[string]string{
+		"":       {"key": "default", "only default": "1"},
+		"first":  {"key": "first", "only first": "2"},
+		"second": {"key": "second"},
+	}
+
+	bc := openStore(t, dir)
+	for name, contents := range want {
+		bucket := bc
+		if name != "" {
+			var err error
+			bucket, err = bc.Bucket(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for key, value := range contents {
+			if err := bucket.Put(key, "old "+value); err != nil {
+				t.Fatal(err)
+			}
+			if err := bucket.Put(key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	second, err := bc.Bucket("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Put("deleted", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	checkBuckets(t, bc, want)
+	bc.Close()
+
+	bc = openStore(t, dir)
+	checkBuckets(t, bc, want)
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	checkBuckets(t, bc, want)
+	bc.Close()
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkBuckets(t, bc, want)
+}
+
+func TestDropBucketMerge(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir)
+	if err := bc.Put("key", "default"); err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := bc.Bucket("dropped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"key", "a", "b"} {
+		if err := dropped.Put(key, "dropped value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bc.DropBucket("dropped"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dropped.Put("c", "3"); err == nil {
+		t.Fatal("Put through the handle of a dropped bucket succeeded")
+	}
+	bc.Close()
+
+	// the records of the dropped bucket are in a file other than the active one after reopening,
+	// so Merge rewrites them.
+	bc = openStore(t, dir)
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+	checkNotInFiles(t, dir, "dropped value")
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkContents(t, bc, map[string]string{"key": "default"})
+	bucket, err := bc.Bucket("dropped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, bucket, map[string]string{})
+	if _, err := bucket.Get("key"); err == nil {
+		t.Fatal("a key of the dropped bucket is found in its new bucket")
+	}
+}
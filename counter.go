@@ -0,0 +1,78 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var (
+	// errNotCounter happens whenever a counter operation is done on a value that is not a counter.
+	errNotCounter = errors.New("value is not a counter")
+	// errCounterOverflow happens whenever a counter operation overflows the counter.
+	errCounterOverflow = errors.New("counter overflow")
+)
+
+// Incr adds delta to the counter stored in the given key and returns its new value.
+// A counter is stored as its base 10 string representation, so it can be read by Get and set by Put.
+// A key that does not exist is treated as a counter of zero, and the expiry of an existing key is kept.
+// The read and the write of the counter are done atomically.
+func (bitcask *Bitcask) Incr(key string, delta int64) (int64, error) {
+	return bitcask.IncrBytes([]byte(key), delta)
+}
+
+// IncrBytes is the binary-safe version of Incr.
+func (bitcask *Bitcask) IncrBytes(key []byte, delta int64) (int64, error) {
+	if bitcask.usrOpts.accessPermission == ReadOnly {
+		return 0, fmt.Errorf("Incr: %s", errRequireWrite)
+	}
+	err := checkKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
+
+	var counter, expiry int64
+	if bitcask.exists(key) {
+		value, _, err := bitcask.get(key)
+		if err != nil {
+			return 0, err
+		}
+		counter, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", key, errNotCounter)
+		}
+		rec, _ := bitcask.keyDir.Get(string(key))
+		expiry = rec.Expiry
+	}
+
+	if (delta > 0 && counter > math.MaxInt64-delta) || (delta < 0 && counter < math.MinInt64-delta) {
+		return 0, fmt.Errorf("%s: %s", key, errCounterOverflow)
+	}
+	counter += delta
+
+	err = bitcask.put(key, strconv.AppendInt(nil, counter, 10), expiry)
+	if err != nil {
+		return 0, err
+	}
+
+	return counter, nil
+}
+
+// Decr subtracts delta from the counter stored in the given key and returns its new value.
+// It follows the same rules of Incr.
+func (bitcask *Bitcask) Decr(key string, delta int64) (int64, error) {
+	return bitcask.DecrBytes([]byte(key), delta)
+}
+
+// DecrBytes is the binary-safe version of Decr.
+func (bitcask *Bitcask) DecrBytes(key []byte, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, fmt.Errorf("%s: %s", key, errCounterOverflow)
+	}
+
+	return bitcask.IncrBytes(key, -delta)
+}
@@ -0,0 +1,81 @@
+package bitcask
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir)
+
+	for _, step := range []struct {
+		delta, want int64
+		decr        bool
+	}{{delta: 5, want: 5}, {delta: -7, want: -2}, {delta: 3, want: -5, decr: true}, {delta: -10, want: 5, decr: true}} {
+		incr := bc.Incr
+		if step.decr {
+			incr = bc.Decr
+		}
+		counter, err := incr("counter", step.delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counter != step.want {
+			t.Fatalf("got counter %d, want %d", counter, step.want)
+		}
+	}
+	bc.Close()
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkContents(t, bc, map[string]string{"counter": "5"})
+}
+
+func TestCounterOverflow(t *testing.T) {
+	bc := openStore(t, t.TempDir())
+	defer bc.Close()
+
+	if _, err := bc.Incr("max", math.MaxInt64); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Incr("max", 1); err == nil || !strings.Contains(err.Error(), errCounterOverflow.Error()) {
+		t.Fatalf("Incr over MaxInt64: got error %v, want %v", err, errCounterOverflow)
+	}
+	if _, err := bc.Incr("min", math.MinInt64); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Decr("min", 1); err == nil || !strings.Contains(err.Error(), errCounterOverflow.Error()) {
+		t.Fatalf("Decr under MinInt64: got error %v, want %v", err, errCounterOverflow)
+	}
+	if _, err := bc.Incr("min", -1); err == nil || !strings.Contains(err.Error(), errCounterOverflow.Error()) {
+		t.Fatalf("Incr under MinInt64: got error %v, want %v", err, errCounterOverflow)
+	}
+
+	// negating MinInt64 overflows, even for a counter it would not overflow.
+	_, err := bc.DecrBytes([]byte("zero"), math.MinInt64)
+	if err == nil || !strings.Contains(err.Error(), errCounterOverflow.Error()) {
+		t.Fatalf("DecrBytes by MinInt64: got error %v, want %v", err, errCounterOverflow)
+	}
+
+	// the failed operations leave the counters unchanged.
+	checkContents(t, bc, map[string]string{"max": "9223372036854775807", "min": "-9223372036854775808"})
+}
+
+func TestCounterNotInteger(t *testing.T) {
+	bc := openStore(t, t.TempDir())
+	defer bc.Close()
+
+	for _, value := range []string{"abc", "1.5", "", " 1", "99999999999999999999"} {
+		if err := bc.Put("key", value); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bc.Incr("key", 1); err == nil || !strings.Contains(err.Error(), errNotCounter.Error()) {
+			t.Fatalf("Incr of %q: got error %v, want %v", value, err, errNotCounter)
+		}
+		if got, err := bc.Get("key"); err != nil || got != value {
+			t.Fatalf("Get after the failed Incr: got %q %v, want %q", got, err, value)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package bitcask
+
+import (
+	"crypto/aes"
+	"errors"
+	"fmt"
+)
+
+var (
+	// errInvalidKey happens whenever a key ring is given an invalid encryption key or key id.
+	errInvalidKey = errors.New("invalid encryption key")
+	// errUnknownKey happens whenever a record is encrypted with a key that is not provided.
+	errUnknownKey = errors.New("unknown encryption key")
+)
+
+type (
+	// KeyProvider provides the AES keys used to encrypt the records of the datastore,
+	// every key is identified by a non zero id stored in the records encrypted with it.
+	// The key of an id must never change, a new key is rotated in by giving it a new id.
+	KeyProvider interface {
+		// CurrentKey returns the id and the key used to encrypt the new records.
+		CurrentKey() (uint32, []byte)
+		// Key returns the key of the given id, used to decrypt the records encrypted with it.
+		Key(id uint32) ([]byte, error)
+	}
+
+	// KeyRing is a KeyProvider holding its keys in memory.
+	KeyRing struct {
+		current uint32
+		keys    map[uint32][]byte
+	}
+)
+
+// WithEncryption encrypts the keys and values of the written records with AES-GCM,
+// using the current key of the given provider.
+// The records written before enabling the encryption are still readable, and the records encrypted
+// with older keys are readable as long as the provider returns their keys.
+// Merge encrypts the merged records with the current key, so a key is rotated by making it current
+// then merging the datastore, after which the older keys are no longer needed.
+// The keys stored in the hint and keydir files are encrypted as well.
+func WithEncryption(provider KeyProvider) Option {
+	return optionFunc(func(usrOpts *options) error {
+		if provider == nil {
+			return fmt.Errorf("nil key provider: %s", errInvalidOpt)
+		}
+		usrOpts.keyProvider = provider
+		return nil
+	})
+}
+
+// NewKeyRing returns a key ring of the given keys mapped by their ids, which encrypts with the key of the current id.
+// The ids must be non zero, and the keys must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256.
+func NewKeyRing(current uint32, keys map[uint32][]byte) (*KeyRing, error) {
+	for id, key := range keys {
+		if id == 0 {
+			return nil, fmt.Errorf("key id %d: %s", id, errInvalidKey)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("key id %d: %s", id, errInvalidKey)
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("key id %d: %s", current, errUnknownKey)
+	}
+
+	ring := &KeyRing{current: current, keys: make(map[uint32][]byte, len(keys))}
+	for id, key := range keys {
+		ring.keys[id] = append([]byte(nil), key...)
+	}
+
+	return ring, nil
+}
+
+// CurrentKey returns the id and the key used to encrypt the new records.
+func (ring *KeyRing) CurrentKey() (uint32, []byte) {
+	return ring.current, ring.keys[ring.current]
+}
+
+// Key returns the key of the given id.
+func (ring *KeyRing) Key(id uint32) ([]byte, error) {
+	key, ok := ring.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key id %d: %s", id, errUnknownKey)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,159 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// testKeys are the encryption keys used by the tests mapped by their ids.
+var testKeys = map[uint32][]byte{
+	1: bytes.Repeat([]byte{1}, 32),
+	2: bytes.Repeat([]byte{2}, 16),
+}
+
+// keyRing returns a key ring of the given test keys encrypting with the first one.
+func keyRing(t *testing.T, ids ...uint32) *KeyRing {
+	t.Helper()
+
+	keys := make(map[uint32][]byte)
+	for _, id := range ids {
+		keys[id] = testKeys[id]
+	}
+	ring, err := NewKeyRing(ids[0], keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ring
+}
+
+// writeSecrets writes keys and values with a recognizable plain text to the datastore, and returns them.
+func writeSecrets(t *testing.T, bc *Bitcask) map[string]string {
+	t.Helper()
+
+	want := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		key, value := fmt.Sprintf("secret-key-%02d", i), fmt.Sprintf("secret-value-%02d", i)
+		if err := bc.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = value
+	}
+
+	return want
+}
+
+func TestKeyRing(t *testing.T) {
+	keys := map[uint32][]byte{1: bytes.Repeat([]byte{1}, 16), 2: bytes.Repeat([]byte{2}, 24)}
+	ring, err := NewKeyRing(2, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the ring keeps its own copy of the keys.
+	keys[2][0] = 0xff
+
+	id, key := ring.CurrentKey()
+	if id != 2 || !bytes.Equal(key, bytes.Repeat([]byte{2}, 24)) {
+		t.Fatalf("got current key %d %x", id, key)
+	}
+	key, err = ring.Key(1)
+	if err != nil || !bytes.Equal(key, bytes.Repeat([]byte{1}, 16)) {
+		t.Fatalf("got key 1 %x %v", key, err)
+	}
+	if _, err := ring.Key(3); err == nil || !strings.Contains(err.Error(), errUnknownKey.Error()) {
+		t.Fatalf("Key of an unknown id: got error %v, want %v", err, errUnknownKey)
+	}
+
+	for name, test := range map[string]struct {
+		current uint32
+		keys    map[uint32][]byte
+		want    error
+	}{
+		"zero id":             {0, map[uint32][]byte{0: testKeys[1]}, errInvalidKey},
+		"invalid key size":    {1, map[uint32][]byte{1: []byte("short")}, errInvalidKey},
+		"unknown current key": {3, testKeys, errUnknownKey},
+	} {
+		if _, err := NewKeyRing(test.current, test.keys); err == nil || !strings.Contains(err.Error(), test.want.Error()) {
+			t.Fatalf("%s: got error %v, want %v", name, err, test.want)
+		}
+	}
+}
+
+func TestEncryptionRotation(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir, WithEncryption(keyRing(t, 1)))
+	want := writeSecrets(t, bc)
+	bc.Close()
+
+	// the records of key 1 are rewritten with key 2 by Merge.
+	bc = openStore(t, dir, WithEncryption(keyRing(t, 2, 1)))
+	checkContents(t, bc, want)
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("after-merge", "value"); err != nil {
+		t.Fatal(err)
+	}
+	want["after-merge"] = "value"
+	bc.Close()
+
+	bc = openStore(t, dir, WithEncryption(keyRing(t, 2)))
+	defer bc.Close()
+	checkContents(t, bc, want)
+}
+
+func TestEncryptionNoPlainText(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir, WithEncryption(keyRing(t, 1)))
+	want := writeSecrets(t, bc)
+	bc.Close()
+
+	// Merge writes the hint files, and a ReadOnly open writes the shared keydir file.
+	bc = openStore(t, dir, WithEncryption(keyRing(t, 1)))
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+	bc, err := Open(dir, WithReadOnly(), WithEncryption(keyRing(t, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, bc, want)
+	bc.Close()
+
+	if len(listFiles(t, dir, ".hint")) == 0 || !fileExists(path.Join(dir, "keydir")) {
+		t.Fatalf("got files %v, want hint files and a keydir file", listFiles(t, dir, ""))
+	}
+	for _, name := range listFiles(t, dir, "") {
+		data, err := os.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret-")) {
+			t.Fatalf("%s holds plain text", name)
+		}
+	}
+}
+
+func TestEncryptionUnknownKey(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir, WithEncryption(keyRing(t, 1)))
+	want := writeSecrets(t, bc)
+	bc.Close()
+
+	for _, opts := range [][]Option{{WithReadWrite()}, {WithReadOnly()}} {
+		_, err := Open(dir, append(opts, WithEncryption(keyRing(t, 2)))...)
+		if err == nil || !strings.Contains(err.Error(), errUnknownKey.Error()) {
+			t.Fatalf("got error %v, want %v", err, errUnknownKey)
+		}
+	}
+
+	// the failed opens leave the datastore unlocked and unchanged.
+	bc = openStore(t, dir, WithEncryption(keyRing(t, 1)))
+	defer bc.Close()
+	checkContents(t, bc, want)
+}
@@ -0,0 +1,343 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask/internal/keydir"
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+const (
+	// reservedKeyPrefix prefixes the keys used internally by the datastore, user keys can not start with it.
+	reservedKeyPrefix = "\x00bitcask:"
+	// indexDefPrefix prefixes the keys recording the created indexes.
+	indexDefPrefix = reservedKeyPrefix + "index:"
+	// indexEntryPrefix prefixes the index entry keys.
+	// an entry key is the prefix followed by the length prefixed index name and term, then the primary key.
+	indexEntryPrefix = reservedKeyPrefix + "entry:"
+)
+
+var (
+	// errReservedKey happens whenever a user tries to write a key starting with the reserved prefix.
+	errReservedKey = errors.New("key uses the reserved prefix")
+	// errIndexExists happens whenever an index is created twice.
+	errIndexExists = errors.New("index already exists")
+	// errIndexNotExist happens whenever an index is used before it is created.
+	errIndexNotExist = errors.New("index does not exist")
+	// errIndexNotLoaded happens whenever a bucket having persisted indexes is written
+	// before their extractors are registered again by CreateIndex since the datastore is opened.
+	errIndexNotLoaded = errors.New("index must be created again with its extractor before writing")
+)
+
+// IndexExtractor returns the index terms of the given key/value pair, a pair may have any number of terms.
+type IndexExtractor func(key, value []byte) [][]byte
+
+// CreateIndex creates an index maintained on every write and looked up with LookupIndex.
+// The index entries are persisted in the datastore and written atomically with the writes they index.
+// Creating the index indexes all the existing keys.
+//
+// The extractor is not persisted, so an existing index must be created again with the same extractor
+// every time the datastore is opened, the writes to the bucket fail until then so that the index misses none of them.
+func (bitcask *Bitcask) CreateIndex(name string, extractor IndexExtractor) error {
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
+
+	if _, ok := bitcask.indexes[name]; ok {
+		return fmt.Errorf("%s: %s", name, errIndexExists)
+	}
+
+	if _, ok := bitcask.reservedKeyDir.Get(indexDefKey(name)); ok {
+		bitcask.indexes[name] = extractor
+		return nil
+	}
+	if bitcask.usrOpts.accessPermission == ReadOnly {
+		return fmt.Errorf("CreateIndex: %s", errRequireWrite)
+	}
+
+	var err error
+	ops := []batchOp{{key: []byte(indexDefKey(name))}}
+
+	now := time.Now().UnixMicro()
+	bitcask.keyDir.Range(func(key string, rec recfmt.KeyDirRec) bool {
+		if rec.IsExpired(now) {
+			return true
+		}
+
+		value, readErr := bitcask.dataStore.ReadValueFromFile(rec.FileId, []byte(key), rec.ValuePos, rec.ValueSize)
+		if readErr != nil {
+			err = readErr
+			return false
+		}
+		for term := range indexTerms(extractor, []byte(key), value) {
+			ops = append(ops, batchOp{key: []byte(indexEntryKey(name, term, key)), expiry: rec.Expiry})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	err = bitcask.write(ops)
+	if err != nil {
+		return err
+	}
+	bitcask.indexes[name] = extractor
+
+	return nil
+}
+
+// DropIndex removes the given index and all of its entries from the datastore.
+func (bitcask *Bitcask) DropIndex(name string) error {
+	if bitcask.usrOpts.accessPermission == ReadOnly {
+		return fmt.Errorf("DropIndex: %s", errRequireWrite)
+	}
+
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
+
+	if _, ok := bitcask.reservedKeyDir.Get(indexDefKey(name)); !ok {
+		return fmt.Errorf("%s: %s", name, errIndexNotExist)
+	}
+
+	ops := []batchOp{{key: []byte(indexDefKey(name)), isDelete: true}}
+	prefix := indexNamePrefix(name)
+	bitcask.reservedKeyDir.Ascend(prefix, prefixEnd([]byte(prefix)), func(key string, _ recfmt.KeyDirRec) bool {
+		ops = append(ops, batchOp{key: []byte(key), isDelete: true})
+		return true
+	})
+
+	err := bitcask.write(ops)
+	if err != nil {
+		return err
+	}
+	delete(bitcask.indexes, name)
+
+	return nil
+}
+
+// LookupIndex returns the keys having the given term in the given index.
+func (bitcask *Bitcask) LookupIndex(name, term string) ([]string, error) {
+	keys, err := bitcask.LookupIndexBytes(name, []byte(term))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, len(keys))
+	for i, key := range keys {
+		res[i] = string(key)
+	}
+
+	return res, nil
+}
+
+// LookupIndexBytes is the binary-safe version of LookupIndex.
+// Only the keys whose current values still have the given term are returned.
+func (bitcask *Bitcask) LookupIndexBytes(name string, term []byte) ([][]byte, error) {
+	bitcask.accessMu.RLock()
+	defer bitcask.accessMu.RUnlock()
+
+	if _, ok := bitcask.indexes[name]; !ok {
+		return nil, fmt.Errorf("%s: %s", name, errIndexNotExist)
+	}
+
+	res := make([][]byte, 0)
+	now := time.Now().UnixMicro()
+	prefix := indexTermPrefix(name, string(term))
+	extractor := bitcask.indexes[name]
+	var err error
+	bitcask.reservedKeyDir.Ascend(prefix, prefixEnd([]byte(prefix)), func(key string, rec recfmt.KeyDirRec) bool {
+		primaryKey := []byte(strings.TrimPrefix(key, prefix))
+		if rec.IsExpired(now) {
+			return true
+		}
+
+		var hasTerm bool
+		hasTerm, err = bitcask.hasTerm(extractor, primaryKey, term)
+		if err != nil {
+			return false
+		}
+		if hasTerm {
+			res = append(res, primaryKey)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// hasTerm reports whether the current value of the given key exists and has the given term,
+// so that a stale index entry is never returned.
+// the caller must hold the access lock.
+func (bitcask *Bitcask) hasTerm(extractor IndexExtractor, key, term []byte) (bool, error) {
+	rec, ok := bitcask.keyDir.Get(string(key))
+	if !ok || rec.IsExpired(time.Now().UnixMicro()) {
+		return false, nil
+	}
+
+	value, err := bitcask.dataStore.ReadValueFromFile(rec.FileId, key, rec.ValuePos, rec.ValueSize)
+	if err != nil {
+		return false, err
+	}
+
+	return indexTerms(extractor, key, value)[string(term)], nil
+}
+
+// checkIndexes returns an error if the bucket has a persisted index whose extractor is not registered,
+// as the writes to the bucket would not maintain it.
+// the caller must hold the access lock.
+func (bitcask *Bitcask) checkIndexes() error {
+	var err error
+	bitcask.reservedKeyDir.Ascend(indexDefPrefix, prefixEnd([]byte(indexDefPrefix)), func(key string, _ recfmt.KeyDirRec) bool {
+		name := strings.TrimPrefix(key, indexDefPrefix)
+		if _, ok := bitcask.indexes[name]; !ok {
+			err = fmt.Errorf("%s: %s", name, errIndexNotLoaded)
+			return false
+		}
+		return true
+	})
+
+	return err
+}
+
+// indexOps returns the index entry writes needed by the given writes.
+// the entries of the old terms not extracted from the new values are deleted,
+// and the entries of the new terms are written with the expiry of their keys.
+// the caller must hold the access lock for writing.
+func (bitcask *Bitcask) indexOps(ops []batchOp) ([]batchOp, error) {
+	indexOps := make([]batchOp, 0)
+	for _, op := range ops {
+		if isReservedKey(op.key) {
+			continue
+		}
+
+		var oldValue []byte
+		if rec, ok := bitcask.keyDir.Get(string(op.key)); ok {
+			value, err := bitcask.dataStore.ReadValueFromFile(rec.FileId, op.key, rec.ValuePos, rec.ValueSize)
+			if err != nil {
+				return nil, err
+			}
+			oldValue = value
+		}
+
+		for name, extractor := range bitcask.indexes {
+			newTerms := make(map[string]bool)
+			if !op.isDelete {
+				newTerms = indexTerms(extractor, op.key, op.value)
+			}
+			for term := range newTerms {
+				indexOps = append(indexOps, batchOp{key: []byte(indexEntryKey(name, term, string(op.key))), expiry: op.expiry})
+			}
+
+			if oldValue == nil {
+				continue
+			}
+			for term := range indexTerms(extractor, op.key, oldValue) {
+				entryKey := indexEntryKey(name, term, string(op.key))
+				if _, ok := bitcask.reservedKeyDir.Get(entryKey); ok && !newTerms[term] {
+					indexOps = append(indexOps, batchOp{key: []byte(entryKey), isDelete: true})
+				}
+			}
+		}
+	}
+
+	return indexOps, nil
+}
+
+// isLiveReservedKey reports whether the given reserved key is still needed,
+// the index entries of deleted or expired keys are not.
+// the caller must hold the access lock.
+func (bitcask *Bitcask) isLiveReservedKey(key string) bool {
+	if !strings.HasPrefix(key, indexEntryPrefix) {
+		return true
+	}
+
+	primaryKey, ok := parseIndexEntryKey(key)
+	return ok && bitcask.exists([]byte(primaryKey))
+}
+
+// keyDirOf returns the keydir holding the given key.
+func (bitcask *Bitcask) keyDirOf(key []byte) keydir.KeyDir {
+	if isReservedKey(key) {
+		return bitcask.reservedKeyDir
+	}
+
+	return bitcask.keyDir
+}
+
+// checkKey returns an error if the given key can not be written by the user.
+func checkKey(key []byte) error {
+	if isReservedKey(key) {
+		return fmt.Errorf("%q: %s", key, errReservedKey)
+	}
+
+	return nil
+}
+
+// hasUserKeys reports whether any of the given writes is of a user key rather than a reserved one.
+func hasUserKeys(ops []batchOp) bool {
+	for _, op := range ops {
+		if !isReservedKey(op.key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isReservedKey(key []byte) bool {
+	return strings.HasPrefix(string(key), reservedKeyPrefix)
+}
+
+// indexTerms returns the distinct terms extracted from the given key/value pair.
+func indexTerms(extractor IndexExtractor, key, value []byte) map[string]bool {
+	terms := make(map[string]bool)
+	for _, term := range extractor(key, value) {
+		terms[string(term)] = true
+	}
+
+	return terms
+}
+
+func indexDefKey(name string) string {
+	return indexDefPrefix + name
+}
+
+// indexNamePrefix returns the prefix of all the entries of the given index.
+func indexNamePrefix(name string) string {
+	return string(appendLenPrefixed([]byte(indexEntryPrefix), name))
+}
+
+// indexTermPrefix returns the prefix of the entries of the given index having the given term.
+func indexTermPrefix(name, term string) string {
+	return string(appendLenPrefixed([]byte(indexNamePrefix(name)), term))
+}
+
+func indexEntryKey(name, term, primaryKey string) string {
+	return indexTermPrefix(name, term) + primaryKey
+}
+
+// parseIndexEntryKey returns the primary key of the given index entry key.
+func parseIndexEntryKey(key string) (string, bool) {
+	rest := []byte(strings.TrimPrefix(key, indexEntryPrefix))
+	for i := 0; i < 2; i++ {
+		size, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < size {
+			return "", false
+		}
+		rest = rest[n+int(size):]
+	}
+
+	return string(rest), true
+}
+
+func appendLenPrefixed(buff []byte, s string) []byte {
+	buff = binary.AppendUvarint(buff, uint64(len(s)))
+	return append(buff, s...)
+}
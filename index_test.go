@@ -0,0 +1,95 @@
+package bitcask
+
+import (
+	"strings"
+	"testing"
+)
+
+// valueExtractor indexes every key by its whole value.
+func valueExtractor(_, value []byte) [][]byte {
+	return [][]byte{value}
+}
+
+func lookup(t *testing.T, bc *Bitcask, term string) []string {
+	t.Helper()
+
+	keys, err := bc.LookupIndex("value", term)
+	if err != nil {
+		t.Fatalf("LookupIndex(%q): %s", term, err)
+	}
+
+	return keys
+}
+
+func TestIndexWriteBeforeCreateAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	bc, err := Open(dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.CreateIndex("value", valueExtractor); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("u1", "x"); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	bc, err = Open(dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	err = bc.Put("u1", "z")
+	if err == nil || !strings.Contains(err.Error(), errIndexNotLoaded.Error()) {
+		t.Fatalf("Put before CreateIndex: got error %v, want %q", err, errIndexNotLoaded)
+	}
+	if err := bc.Delete("u1"); err == nil {
+		t.Fatal("Delete before CreateIndex succeeded")
+	}
+
+	if err := bc.CreateIndex("value", valueExtractor); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("u1", "z"); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := lookup(t, bc, "x"); len(keys) != 0 {
+		t.Fatalf("LookupIndex(x): got %v, want none", keys)
+	}
+	if keys := lookup(t, bc, "z"); len(keys) != 1 || keys[0] != "u1" {
+		t.Fatalf("LookupIndex(z): got %v, want [u1]", keys)
+	}
+}
+
+func TestIndexDropWithoutCreate(t *testing.T) {
+	dir := t.TempDir()
+
+	bc, err := Open(dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.CreateIndex("value", valueExtractor); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("u1", "x"); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	bc, err = Open(dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	if err := bc.DropIndex("value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("u1", "z"); err != nil {
+		t.Fatalf("Put after DropIndex: %s", err)
+	}
+}
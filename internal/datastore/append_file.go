@@ -1,9 +1,12 @@
 package datastore
 
 import (
+	"compress/flate"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"sync/atomic"
 	"time"
 
 	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
@@ -16,14 +19,28 @@ const (
 	// Merge represents that the file type is an active file.
 	Active AppendType = 1
 
-	// maxFileSize represents the maximum size for each file.
-	maxFileSize = 10 * 1024
+	// DefaultMaxFileSize represents the default maximum size for each file.
+	DefaultMaxFileSize = 1 << 30
+	// DefaultFileMode represents the default permission bits of the datastore files.
+	DefaultFileMode = os.FileMode(0666)
+	// DefaultDirMode represents the default permission bits of the datastore directory.
+	DefaultDirMode = os.FileMode(0777)
 )
 
 type (
 	// AppendType represents the type of the append file.
 	AppendType int
 
+	// BatchRec represents a single record of a write batch.
+	BatchRec struct {
+		BucketId uint32
+		Key      []byte
+		Value    []byte
+		Expiry   int64
+		// IsDelete makes the record a tombstone of its key, its value is ignored.
+		IsDelete bool
+	}
+
 	// AppendFile contains the metadata about the append file.
 	AppendFile struct {
 		fileWrapper *sio.File
@@ -31,37 +48,234 @@ type (
 		fileName    string
 		filePath    string
 		fileFlags   int
+		config      Config
 		appendType  AppendType
-		currentPos  int
-		currentSize int
+		currentPos  int64
+		currentSize int64
 	}
 )
 
-func (appendFile *AppendFile) WriteData(key, value string, tStamp int64) (int, error) {
-	rec := recfmt.CompressDataFileRec(key, value, tStamp)
+// WriteData appends a data record of the given key and value to the append file.
+// bucketId is the id of the bucket the key belongs to.
+// expiry is the time in unix microseconds after which the record expires, zero means it never expires.
+// The value is compressed if the compression is enabled and the value is large enough,
+// then the record is encrypted if the encryption is enabled.
+// Returns the keydir record of the written record.
+// Returns an error if the key or the value is too large to be written.
+func (appendFile *AppendFile) WriteData(bucketId uint32, key, value []byte, tStamp, expiry int64) (recfmt.KeyDirRec, error) {
+	err := appendFile.checkRecSize(key, int64(len(value)))
+	if err != nil {
+		return recfmt.KeyDirRec{}, err
+	}
+	value, flags, err := appendFile.encodeValue(value)
+	if err != nil {
+		return recfmt.KeyDirRec{}, err
+	}
+	rec, err := appendFile.config.Encrypter.CompressDataFileRec(recfmt.RecPut, bucketId, flags, key, value, tStamp, expiry)
+	if err != nil {
+		return recfmt.KeyDirRec{}, err
+	}
+
+	return appendFile.writeRec(rec, bucketId, key, tStamp, expiry)
+}
+
+// WriteTompStone appends a tombstone of the given key to the append file, marking the key as deleted.
+func (appendFile *AppendFile) WriteTompStone(bucketId uint32, key []byte, tStamp int64) error {
+	err := appendFile.checkRecSize(key, 0)
+	if err != nil {
+		return err
+	}
+	rec, err := appendFile.config.Encrypter.CompressDataFileRec(recfmt.RecDelete, bucketId, 0, key, nil, tStamp, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = appendFile.writeRec(rec, bucketId, key, tStamp, 0)
+	return err
+}
 
-	if appendFile.fileWrapper == nil || len(rec)+appendFile.currentSize > maxFileSize {
+// writeRec appends the given compressed data file record of the given key to the append file,
+// rotating the file first if the record does not fit.
+// Returns the keydir record of the written record.
+func (appendFile *AppendFile) writeRec(rec []byte, bucketId uint32, key []byte, tStamp, expiry int64) (recfmt.KeyDirRec, error) {
+	if appendFile.fileWrapper == nil || int64(len(rec))+appendFile.currentSize > appendFile.config.MaxFileSize {
 		err := appendFile.newAppendFile()
 		if err != nil {
-			return 0, err
+			return recfmt.KeyDirRec{}, err
 		}
 	}
 
 	n, err := appendFile.fileWrapper.Write(rec)
 	if err != nil {
-		return 0, err
+		return recfmt.KeyDirRec{}, err
 	}
 
 	writePos := appendFile.currentPos
-	appendFile.currentPos += n
-	appendFile.currentSize += n
+	appendFile.currentPos += int64(n)
+	appendFile.currentSize += int64(n)
 
-	return writePos, nil
+	return recfmt.KeyDirRec{
+		FileId:    appendFile.fileName,
+		ValuePos:  writePos,
+		ValueSize: uint32(len(rec) - recfmt.DataFileHdrSize - len(key)),
+		TStamp:    tStamp,
+		Expiry:    expiry,
+		BucketId:  bucketId,
+	}, nil
 }
 
+// WriteBatch appends the given records surrounded by the batch begin and commit markers
+// to the append file in a single write.
+// Returns the keydir records of the written records in the same order.
+// Returns an error if any of the records or the whole batch is too large to be written.
+func (appendFile *AppendFile) WriteBatch(recs []BatchRec, tStamp int64) ([]recfmt.KeyDirRec, error) {
+	buff := recfmt.CompressBatchBeginRec(tStamp)
+
+	keyDirRecs := make([]recfmt.KeyDirRec, len(recs))
+	for i, rec := range recs {
+		err := appendFile.checkRecSize(rec.Key, int64(len(rec.Value)))
+		if err != nil {
+			return nil, err
+		}
+		recType, value, flags := recfmt.RecDelete, []byte(nil), byte(0)
+		if !rec.IsDelete {
+			recType = recfmt.RecPut
+			value, flags, err = appendFile.encodeValue(rec.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		dataRec, err := appendFile.config.Encrypter.CompressDataFileRec(recType, rec.BucketId, flags, rec.Key, value,
+			tStamp, rec.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		keyDirRecs[i] = recfmt.KeyDirRec{
+			ValuePos:  int64(len(buff)),
+			ValueSize: uint32(len(dataRec) - recfmt.DataFileHdrSize - len(rec.Key)),
+			TStamp:    tStamp,
+			Expiry:    rec.Expiry,
+			BucketId:  rec.BucketId,
+		}
+		buff = append(buff, dataRec...)
+	}
+	buff = append(buff, recfmt.CompressBatchCommitRec(tStamp)...)
+
+	if appendFile.appendType == Active && int64(len(buff)) > appendFile.config.MaxFileSize {
+		return nil, fmt.Errorf("%d bytes: %s", len(buff), ErrBatchTooLarge)
+	}
+	if appendFile.fileWrapper == nil || int64(len(buff))+appendFile.currentSize > appendFile.config.MaxFileSize {
+		err := appendFile.newAppendFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	n, err := appendFile.fileWrapper.Write(buff)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keyDirRecs {
+		keyDirRecs[i].FileId = appendFile.fileName
+		keyDirRecs[i].ValuePos += appendFile.currentPos
+	}
+	appendFile.currentPos += int64(n)
+	appendFile.currentSize += int64(n)
+
+	return keyDirRecs, nil
+}
+
+// WriteDataFrom appends a data record of the given key and a value of the given size read from r to the append file.
+// r provides the value as it is stored, which is compressed if flags has recfmt.FlagCompressed.
+// The value is streamed to the file without being held in memory, and the record is removed if r fails
+// to provide the whole value.
+// The value is read in memory if the encryption is enabled, since the records are encrypted and authenticated
+// as a whole.
+// Returns the keydir record of the written record.
+func (appendFile *AppendFile) WriteDataFrom(bucketId uint32, key []byte, r io.Reader, size int64, flags byte,
+	tStamp, expiry int64) (recfmt.KeyDirRec, error) {
+	err := appendFile.checkRecSize(key, size)
+	if err != nil {
+		return recfmt.KeyDirRec{}, err
+	}
+	if appendFile.config.Encrypter != nil {
+		value := make([]byte, size)
+		_, err := io.ReadFull(r, value)
+		if err != nil {
+			return recfmt.KeyDirRec{}, err
+		}
+		rec, err := appendFile.config.Encrypter.CompressDataFileRec(recfmt.RecPut, bucketId, flags, key, value, tStamp,
+			expiry)
+		if err != nil {
+			return recfmt.KeyDirRec{}, err
+		}
+		return appendFile.writeRec(rec, bucketId, key, tStamp, expiry)
+	}
+	hdr := recfmt.CompressDataFileHdr(bucketId, flags, key, uint32(size), tStamp, expiry)
+
+	recSize := int64(len(hdr)) + size
+	if appendFile.fileWrapper == nil || recSize+appendFile.currentSize > appendFile.config.MaxFileSize {
+		err := appendFile.newAppendFile()
+		if err != nil {
+			return recfmt.KeyDirRec{}, err
+		}
+	}
+
+	writePos := appendFile.currentPos
+	checkSum := recfmt.NewCheckSum()
+	checkSum.Write(hdr[4:])
+
+	_, err = appendFile.fileWrapper.Write(hdr)
+	if err == nil {
+		err = appendFile.copyValue(io.TeeReader(r, checkSum), size)
+	}
+	if err == nil {
+		recfmt.SetCheckSum(hdr, checkSum.Sum32())
+		_, err = appendFile.fileWrapper.File.WriteAt(hdr[:4], writePos)
+	}
+	if err != nil {
+		appendFile.fileWrapper.File.Truncate(writePos)
+		appendFile.fileWrapper.File.Seek(writePos, io.SeekStart)
+		return recfmt.KeyDirRec{}, err
+	}
+
+	appendFile.currentPos += recSize
+	appendFile.currentSize += recSize
+
+	return recfmt.KeyDirRec{
+		FileId:    appendFile.fileName,
+		ValuePos:  writePos,
+		ValueSize: uint32(size),
+		TStamp:    tStamp,
+		Expiry:    expiry,
+		BucketId:  bucketId,
+	}, nil
+}
+
+// copyValue copies exactly size bytes from r to the append file.
+func (appendFile *AppendFile) copyValue(r io.Reader, size int64) error {
+	n, err := io.Copy(appendFile.fileWrapper.File, io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	if n < size {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+// WriteHint appends a hint record of the given key and keydir record to the hint file,
+// the key is encrypted if the encryption is enabled.
 func (appendFile *AppendFile) WriteHint(key string, rec recfmt.KeyDirRec) error {
-	buff := recfmt.CompressHintFileRec(key, rec)
-	_, err := appendFile.hintWrapper.Write(buff)
+	keyId, sealedKey, err := appendFile.config.Encrypter.SealKey([]byte(key))
+	if err != nil {
+		return err
+	}
+
+	buff := recfmt.CompressHintFileRec(sealedKey, keyId, rec)
+	_, err = appendFile.hintWrapper.Write(buff)
 	if err != nil {
 		return err
 	}
@@ -74,6 +288,11 @@ func (appendFile *AppendFile) Name() string {
 	return appendFile.fileName
 }
 
+// Size returns the size in bytes of the append file.
+func (appendFile *AppendFile) Size() int64 {
+	return appendFile.currentSize
+}
+
 // Sync flushes the data written to the append file to the disk.
 func (appendFile *AppendFile) Sync() error {
 	if appendFile.fileWrapper != nil {
@@ -93,6 +312,76 @@ func (appendFile *AppendFile) Close() {
 	}
 }
 
+// encodeValue returns the value as it is stored in its data file record along with the record flags.
+// the value is compressed if it is at least of the compression threshold and compressing it saves space.
+func (appendFile *AppendFile) encodeValue(value []byte) ([]byte, byte, error) {
+	threshold := appendFile.config.CompressThreshold
+	if threshold <= 0 || len(value) < threshold {
+		return value, 0, nil
+	}
+
+	compressed, err := recfmt.CompressValue(value, flate.DefaultCompression)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(compressed) >= len(value) {
+		return value, 0, nil
+	}
+
+	return compressed, recfmt.FlagCompressed, nil
+}
+
+// checkRecSize returns an error if the record of the given key and value size can not be written.
+// the records appended to the active file must fit in a data file of the max file size,
+// while the merged records are always written as they were accepted before.
+func (appendFile *AppendFile) checkRecSize(key []byte, valueSize int64) error {
+	if len(key) > recfmt.MaxKeySize {
+		return fmt.Errorf("%d bytes: %s", len(key), ErrKeyTooLarge)
+	}
+	valueSize += int64(appendFile.config.Encrypter.Overhead())
+	if valueSize < 0 || valueSize > recfmt.MaxValueSize {
+		return fmt.Errorf("%d bytes: %s", valueSize, ErrValueTooLarge)
+	}
+
+	recSize := int64(recfmt.DataFileHdrSize+len(key)) + valueSize
+	if appendFile.appendType == Active && recSize > appendFile.config.MaxFileSize {
+		return fmt.Errorf("%d bytes: %s", valueSize, ErrValueTooLarge)
+	}
+
+	return nil
+}
+
+// lastFileId is the id of the last data file named by the process.
+var lastFileId atomic.Int64
+
+// nextFileName returns the name of a new data file, named by the current time in unix microseconds.
+// the ids are taken from a counter shared by all the append files, as the active file and the merge files
+// are created concurrently and must never share a name, so an id is never reused even within the same microsecond.
+// the ids of the existing files are skipped as well, in case the clock went back since they were created.
+func (appendFile *AppendFile) nextFileName() string {
+	for {
+		last := lastFileId.Load()
+		id := time.Now().UnixMicro()
+		if id <= last {
+			id = last + 1
+		}
+		if !lastFileId.CompareAndSwap(last, id) {
+			continue
+		}
+
+		fileName := fmt.Sprintf("%d.data", id)
+		if !fileExists(path.Join(appendFile.filePath, fileName)) &&
+			!fileExists(path.Join(appendFile.filePath, fileName+tmpExt)) {
+			return fileName
+		}
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
 func (appendFile *AppendFile) newAppendFile() error {
 	if appendFile.fileWrapper != nil {
 		err := appendFile.fileWrapper.File.Close()
@@ -107,18 +396,22 @@ func (appendFile *AppendFile) newAppendFile() error {
 		}
 	}
 
-	tStamp := time.Now().UnixMicro()
-	fileName := fmt.Sprintf("%d.data", tStamp)
-	file, err := sio.OpenFile(path.Join(appendFile.filePath, fileName),
-		appendFile.fileFlags, os.FileMode(0666))
+	// the merge files are written to temporary names until the merge is committed by DataStore.CommitMerge.
+	ext := ""
+	if appendFile.appendType == Merge {
+		ext = tmpExt
+	}
+
+	fileName := appendFile.nextFileName()
+	file, err := sio.OpenFile(path.Join(appendFile.filePath, fileName+ext),
+		appendFile.fileFlags, appendFile.config.FileMode)
 	if err != nil {
 		return err
 	}
 
 	if appendFile.appendType == Merge {
-		hintName := fmt.Sprintf("%d.hint", tStamp)
-		hint, err := sio.OpenFile(path.Join(appendFile.filePath, hintName),
-			appendFile.fileFlags, os.FileMode(0666))
+		hint, err := sio.OpenFile(path.Join(appendFile.filePath, hintName(fileName)+ext),
+			appendFile.fileFlags, appendFile.config.FileMode)
 		if err != nil {
 			return err
 		}
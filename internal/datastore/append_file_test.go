@@ -0,0 +1,59 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNextFileNameUnique(t *testing.T) {
+	dir := t.TempDir()
+	active := NewAppendFile(dir, os.O_CREATE|os.O_RDWR, Active, Config{})
+	merge := NewAppendFile(dir, os.O_CREATE|os.O_RDWR, Merge, Config{})
+
+	const count = 10000
+	names := make([][]string, 2)
+	var wg sync.WaitGroup
+	for i, appendFile := range []*AppendFile{active, merge} {
+		wg.Add(1)
+		go func(i int, appendFile *AppendFile) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				names[i] = append(names[i], appendFile.nextFileName())
+			}
+		}(i, appendFile)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, list := range names {
+		for _, name := range list {
+			if seen[name] {
+				t.Fatalf("file name %s is used twice", name)
+			}
+			seen[name] = true
+		}
+	}
+}
+
+func TestNextFileNameSkipsExisting(t *testing.T) {
+	dir := t.TempDir()
+	appendFile := NewAppendFile(dir, os.O_CREATE|os.O_RDWR, Active, Config{})
+
+	// files named ahead of the clock, as if it went back since they were created.
+	ahead := time.Now().Add(time.Hour).UnixMicro()
+	for _, name := range []string{fmt.Sprintf("%d.data", ahead+1), fmt.Sprintf("%d.data%s", ahead+2, tmpExt)} {
+		if err := os.WriteFile(path.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lastFileId.Store(ahead)
+
+	want := fmt.Sprintf("%d.data", ahead+3)
+	if name := appendFile.nextFileName(); name != want {
+		t.Fatalf("got file name %s, want %s", name, want)
+	}
+}
@@ -0,0 +1,129 @@
+package datastore
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// compressionThreshold is the compression threshold of the append files written by the tests.
+const compressionThreshold = 64
+
+// writeValue writes the given value to a new append file compressing the values of at least compressionThreshold
+// bytes, and returns the datastore reading it, its keydir record and the flags of the written record.
+func writeValue(t *testing.T, value []byte) (*DataStore, recfmt.KeyDirRec, byte) {
+	t.Helper()
+
+	dir := t.TempDir()
+	config := Config{MaxFileSize: DefaultMaxFileSize, FileMode: 0o644, CompressThreshold: compressionThreshold}
+	appendFile := NewAppendFile(dir, os.O_CREATE|os.O_RDWR, Active, config)
+	defer appendFile.Close()
+
+	rec, err := appendFile.WriteData(0, []byte("key"), value, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path.Join(dir, rec.FileId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := recfmt.ExtractDataFileRec(data[rec.ValuePos:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &DataStore{path: dir, config: config}, rec, parsed.Flags
+}
+
+// checkValue checks that the value of the given record reads as the given value, whole and streamed.
+func checkValue(t *testing.T, d *DataStore, rec recfmt.KeyDirRec, want []byte) {
+	t.Helper()
+
+	value, err := d.ReadValueFromFile(rec.FileId, []byte("key"), rec.ValuePos, rec.ValueSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, want) {
+		t.Fatalf("ReadValueFromFile: got a value of %d bytes, want %d", len(value), len(want))
+	}
+
+	r, err := d.OpenValue(rec.FileId, []byte("key"), rec.ValuePos, rec.ValueSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	value, err = io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value, want) {
+		t.Fatalf("OpenValue: got a value of %d bytes, want %d", len(value), len(want))
+	}
+}
+
+func TestCompressionFlag(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"field": "compressible"}`), 100)
+	d, rec, flags := writeValue(t, value)
+
+	if flags&recfmt.FlagCompressed == 0 {
+		t.Fatal("a compressible value is stored without the compression flag")
+	}
+	if int(rec.ValueSize) >= len(value) {
+		t.Fatalf("got a stored value of %d bytes, want less than %d", rec.ValueSize, len(value))
+	}
+	checkValue(t, d, rec, value)
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	random := make([]byte, 1024)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for name, value := range map[string][]byte{
+		"below the threshold": bytes.Repeat([]byte("a"), compressionThreshold-1),
+		"empty":               {},
+		// compressing a random value does not make it smaller.
+		"incompressible": random,
+	} {
+		d, rec, flags := writeValue(t, value)
+		if flags&recfmt.FlagCompressed != 0 {
+			t.Fatalf("%s: the value is stored compressed", name)
+		}
+		if int(rec.ValueSize) != len(value) {
+			t.Fatalf("%s: got a stored value of %d bytes, want %d", name, rec.ValueSize, len(value))
+		}
+		checkValue(t, d, rec, value)
+	}
+}
+
+func TestCompressionCorruptedValue(t *testing.T) {
+	value := bytes.Repeat([]byte("compressible "), 100)
+	d, rec, _ := writeValue(t, value)
+
+	file, err := os.OpenFile(path.Join(d.path, rec.FileId), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteAt([]byte{0xff}, rec.ValuePos+int64(recfmt.DataFileHdrSize+len("key")+1))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.ReadValueFromFile(rec.FileId, []byte("key"), rec.ValuePos, rec.ValueSize); err == nil {
+		t.Fatal("ReadValueFromFile of a corrupted compressed value succeeded")
+	}
+	r, err := d.OpenValue(rec.FileId, []byte("key"), rec.ValuePos, rec.ValueSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("streaming a corrupted compressed value succeeded")
+	}
+}
@@ -1,8 +1,10 @@
 package datastore
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 
@@ -17,9 +19,6 @@ const (
 	// SharedLock is an option to make the datastore lock shared.
 	SharedLock LockMode = 1
 
-	// TompStone is a special value to mark the deleted values.
-	TompStone = "8890fc70294d02dbde257989e802451c2276be7fb177c3ca4399dc4728e4e1e0"
-
 	// lockFile is the name of the file used to lock the datastore directory.
 	lockFile = ".lck"
 )
@@ -31,24 +30,61 @@ var (
 
 	// ErrKeyNotExist happens when accessing value does not exist.
 	ErrKeyNotExist = errors.New("key does not exist")
+	// ErrKeyTooLarge happens when writing a key larger than recfmt.MaxKeySize.
+	ErrKeyTooLarge = errors.New("key is too large")
+	// ErrValueTooLarge happens when writing a value larger than recfmt.MaxValueSize,
+	// or a record that does not fit in a data file of the max file size.
+	ErrValueTooLarge = errors.New("value is too large")
+	// ErrBatchTooLarge happens when writing a batch that does not fit in a data file of the max file size.
+	ErrBatchTooLarge = errors.New("batch is too large")
+	// ErrNotStreamable happens when streaming a value that can only be read or written as a whole,
+	// and that is larger than MaxBufferedValue.
+	ErrNotStreamable = errors.New("value can not be streamed and is too large to be buffered")
 )
 
 type (
 	// LockMode represents the lock mode of the directory.
 	LockMode int
 
+	// Config groups the tunables of the datastore files.
+	Config struct {
+		// MaxFileSize is the size after which the append files are rotated.
+		MaxFileSize int64
+		// FileMode is the permission bits used to create the datastore files.
+		FileMode os.FileMode
+		// DirMode is the permission bits used to create the datastore directory.
+		DirMode os.FileMode
+		// CompressThreshold is the size from which the written values are compressed, zero disables the compression.
+		CompressThreshold int
+		// Encrypter encrypts the written records and decrypts the read ones, nil disables the encryption.
+		Encrypter *recfmt.Encrypter
+	}
+
+	// valueReadCloser streams a value from its data file and closes the file when closed.
+	valueReadCloser struct {
+		io.Reader
+		io.Closer
+	}
+
 	// DataStore represents and contains the metadata of the datastore directory.
 	DataStore struct {
 		path    string
 		lckMode LockMode
+		config  Config
 		flck    *flock.Flock
 	}
 )
 
-func NewDataStore(dataStorePath string, mode LockMode) (*DataStore, error) {
+// NewDataStore opens the datastore directory at the given path, creating it if it does not exist and mode is
+// ExclusiveLock, and locks it with the given mode.
+// The format of an existing datastore is checked against FormatVersion and upgraded if older,
+// which requires an exclusive lock, and the format and config are recorded in the MANIFEST file.
+// An interrupted merge is completed or rolled back if the datastore is locked exclusively.
+func NewDataStore(dataStorePath string, mode LockMode, config Config) (*DataStore, error) {
 	datastore := &DataStore{
 		path:    dataStorePath,
 		lckMode: mode,
+		config:  config,
 	}
 
 	dir, dirErr := os.Open(dataStorePath)
@@ -74,43 +110,143 @@ func NewDataStore(dataStorePath string, mode LockMode) (*DataStore, error) {
 	} else {
 		return nil, dirErr
 	}
+
+	err := datastore.recover()
+	if err != nil {
+		datastore.Close()
+		return nil, err
+	}
 	return datastore, nil
 }
 
-func NewAppendFile(dataStorePath string, fileFlags int, appendType AppendType) *AppendFile {
+// recover completes or rolls back an interrupted merge and removes the spooled values left by a crash
+// if the datastore is locked exclusively, then checks the format of the datastore files and upgrades them if needed.
+func (d *DataStore) recover() error {
+	if d.lckMode == ExclusiveLock {
+		err := d.RecoverMerge()
+		if err != nil {
+			return err
+		}
+		err = d.removeSpoolFiles()
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.loadManifest()
+}
+
+func NewAppendFile(dataStorePath string, fileFlags int, appendType AppendType, config Config) *AppendFile {
 	return &AppendFile{
 		filePath:   dataStorePath,
 		fileFlags:  fileFlags,
+		config:     config,
 		appendType: appendType,
 	}
 }
 
-func (d *DataStore) ReadValueFromFile(fileId, key string, valuePos, valueSize uint32) (string, error) {
-	buff := make([]byte, recfmt.DataFileHdrSize+uint32(len(key))+valueSize)
+// ReadValueFromFile reads the value of the record stored at the given position of the given file.
+// valueSize is the size of the value as stored in the file, a compressed value is returned decompressed.
+func (d *DataStore) ReadValueFromFile(fileId string, key []byte, valuePos int64, valueSize uint32) ([]byte, error) {
+	f, err := sio.Open(path.Join(d.path, fileId))
+	if err != nil {
+		return nil, err
+	}
+	defer f.File.Close()
 
+	return d.readValue(f, key, valuePos, valueSize)
+}
+
+// ReadValuesFromFile reads the values of the given keys from their records in the given file,
+// opening the file once and reading the records in the given order.
+// Returns the values in the same order of the keys.
+func (d *DataStore) ReadValuesFromFile(fileId string, keys [][]byte, recs []recfmt.KeyDirRec) ([][]byte, error) {
 	f, err := sio.Open(path.Join(d.path, fileId))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer f.File.Close()
 
-	f.ReadAt(buff, int64(valuePos))
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i], err = d.readValue(f, key, recs[i].ValuePos, recs[i].ValueSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// readValue reads the value of the record stored at the given position of the given open file.
+func (d *DataStore) readValue(f *sio.File, key []byte, valuePos int64, valueSize uint32) ([]byte, error) {
+	buff := make([]byte, recfmt.DataFileHdrSize+len(key)+int(valueSize))
+
+	_, err := f.ReadAt(buff, valuePos)
+	if err != nil {
+		return nil, err
+	}
+
 	data, _, err := recfmt.ExtractDataFileRec(buff)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	err = d.config.Encrypter.Decrypt(data)
+	if err != nil {
+		return nil, err
 	}
 
-	if data.Value == TompStone {
-		return "", fmt.Errorf("%s: %s", data.Key, ErrKeyNotExist)
+	if data.IsTompStone() {
+		return nil, fmt.Errorf("%s: %s", data.Key, ErrKeyNotExist)
+	}
+	if data.IsCompressed() {
+		return recfmt.DecompressValue(data.Value)
 	}
 
 	return data.Value, nil
 }
 
+// OpenValue opens a reader streaming the value of the record stored at the given position of the given file.
+// The reader validates the record checksum once the whole value is read, and it must be closed when no longer needed.
+// A compressed value is decompressed while read, while an encrypted value is read and decrypted as a whole,
+// as it is authenticated as a whole, so an encrypted value larger than MaxBufferedValue is refused.
+func (d *DataStore) OpenValue(fileId string, key []byte, valuePos int64, valueSize uint32) (io.ReadCloser, error) {
+	f, err := sio.Open(path.Join(d.path, fileId))
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := make([]byte, recfmt.DataFileHdrSize+len(key))
+	_, err = f.ReadAt(hdr, valuePos)
+	if err != nil {
+		f.File.Close()
+		return nil, err
+	}
+	if recfmt.IsEncryptedHdr(hdr) {
+		f.File.Close()
+		if int64(valueSize) > MaxBufferedValue {
+			return nil, fmt.Errorf("%d bytes: %s", valueSize, ErrNotStreamable)
+		}
+		value, err := d.ReadValueFromFile(fileId, key, valuePos, valueSize)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(value)), nil
+	}
+
+	value := io.NewSectionReader(f.File, valuePos+int64(len(hdr)), recfmt.MaxValueSize)
+	return &valueReadCloser{Reader: recfmt.NewValueReader(hdr, value), Closer: f.File}, nil
+}
+
 func (dataStore *DataStore) Path() string {
 	return dataStore.path
 }
 
+// Config returns the config the datastore is opened with.
+func (dataStore *DataStore) Config() Config {
+	return dataStore.config
+}
+
 // Close frees the acquired lock on the datastore directory.
 func (dataStore *DataStore) Close() {
 	dataStore.flck.Unlock()
@@ -126,7 +262,7 @@ func (dataStore *DataStore) openDataStoreDir() (bool, error) {
 }
 
 func (dataStore *DataStore) createDataStoreDir() error {
-	err := os.MkdirAll(dataStore.path, os.FileMode(0777))
+	err := os.MkdirAll(dataStore.path, dataStore.config.DirMode)
 	if err != nil {
 		return err
 	}
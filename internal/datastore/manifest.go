@@ -0,0 +1,214 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FormatVersion is the version of the format of the datastore files written by this package.
+	// Version 1 is the format of the datastores created before the manifest was added,
+	// whose records have an 18 bytes header without record types, buckets nor expiry.
+	FormatVersion = 2
+
+	// manifestFile is the name of the file recording the format and the options of the datastore files.
+	manifestFile = "MANIFEST"
+
+	// checkSumCRC32 is the checksum algorithm of the data file records.
+	checkSumCRC32 = "crc32-ieee"
+)
+
+var (
+	// errUnknownVersion happens when opening a datastore written in a format newer than FormatVersion.
+	errUnknownVersion = errors.New("unknown datastore format version, it is written by a newer version")
+	// errMigrationRequired happens when opening a datastore written in an older format without an exclusive lock.
+	errMigrationRequired = errors.New("datastore is written in an older format, open it with write permission to migrate it")
+	// errUnknownCheckSum happens when opening a datastore whose records use an unsupported checksum algorithm.
+	errUnknownCheckSum = errors.New("unknown checksum algorithm")
+	// errInvalidManifest happens when the manifest can not be parsed.
+	errInvalidManifest = errors.New("invalid manifest")
+	// errUnknownFormat happens when a datastore without a manifest has a data file written in no known format.
+	errUnknownFormat = errors.New("data file is written in an unknown format")
+)
+
+type (
+	// manifest describes the format of the datastore files and the options of the last writer.
+	// the options are informational, as every record carries how it is stored.
+	manifest struct {
+		version           int
+		checkSum          string
+		compressThreshold int
+		encrypted         bool
+		maxFileSize       int64
+	}
+
+	// migration upgrades the datastore files in place from a format version to the next one.
+	// a migration must be safe to run again if interrupted, as the version is only recorded once all of them succeed.
+	migration func(d *DataStore) error
+)
+
+// migrations maps every format version older than FormatVersion to the migration upgrading it.
+var migrations = map[int]migration{
+	1: (*DataStore).migrateRecordTypes,
+}
+
+// loadManifest checks the format version of the datastore files and upgrades them if older than FormatVersion,
+// then records the current format and options in the manifest.
+// The datastores created before the manifest are of version 1 if any of their data files is of the version 1 format.
+// Returns an error if the format is unknown, or if it needs an upgrade and the datastore is not locked exclusively.
+func (d *DataStore) loadManifest() error {
+	current, err := d.readManifest()
+	if err != nil {
+		return err
+	}
+
+	if current.version > FormatVersion || current.version < 1 {
+		return fmt.Errorf("%s version %d: %s", manifestFile, current.version, errUnknownVersion)
+	}
+	if current.checkSum != checkSumCRC32 {
+		return fmt.Errorf("%s checksum %q: %s", manifestFile, current.checkSum, errUnknownCheckSum)
+	}
+	if d.lckMode != ExclusiveLock {
+		if current.version < FormatVersion {
+			return fmt.Errorf("%s version %d: %s", manifestFile, current.version, errMigrationRequired)
+		}
+		return nil
+	}
+
+	for version := current.version; version < FormatVersion; version++ {
+		err := migrations[version](d)
+		if err != nil {
+			return fmt.Errorf("migrating version %d: %s", version, err)
+		}
+	}
+
+	updated := d.newManifest()
+	if updated == current {
+		return nil
+	}
+	return writeFileSync(path.Join(d.path, manifestFile), updated.encode(), d.config.FileMode)
+}
+
+// newManifest returns the manifest of the current format and the options of the datastore.
+func (d *DataStore) newManifest() manifest {
+	return manifest{
+		version:           FormatVersion,
+		checkSum:          checkSumCRC32,
+		compressThreshold: d.config.CompressThreshold,
+		encrypted:         d.config.Encrypter != nil,
+		maxFileSize:       d.config.MaxFileSize,
+	}
+}
+
+// readManifest reads the manifest of the datastore,
+// the manifest of a datastore created before it is detected from its data files,
+// which fails if any of them is written in an unknown format.
+func (d *DataStore) readManifest() (manifest, error) {
+	data, err := os.ReadFile(path.Join(d.path, manifestFile))
+	if os.IsNotExist(err) {
+		legacyFiles, err := d.legacyFiles()
+		if err != nil {
+			return manifest{}, err
+		}
+		res := manifest{version: FormatVersion, checkSum: checkSumCRC32}
+		if len(legacyFiles) > 0 {
+			res.version = 1
+		}
+		return res, nil
+	}
+	if err != nil {
+		return manifest{}, err
+	}
+
+	res, err := decodeManifest(data)
+	if err != nil {
+		return manifest{}, fmt.Errorf("%s: %s", manifestFile, err)
+	}
+
+	return res, nil
+}
+
+// encode returns the manifest as lines of names and values.
+func (m manifest) encode() []byte {
+	compression, encryption := "none", "none"
+	if m.compressThreshold > 0 {
+		compression = "deflate"
+	}
+	if m.encrypted {
+		encryption = "aes-gcm"
+	}
+
+	var buff bytes.Buffer
+	fmt.Fprintf(&buff, "version %d\n", m.version)
+	fmt.Fprintf(&buff, "checksum %s\n", m.checkSum)
+	fmt.Fprintf(&buff, "compression %s\n", compression)
+	fmt.Fprintf(&buff, "compress-threshold %d\n", m.compressThreshold)
+	fmt.Fprintf(&buff, "encryption %s\n", encryption)
+	fmt.Fprintf(&buff, "max-file-size %d\n", m.maxFileSize)
+
+	return buff.Bytes()
+}
+
+// decodeManifest parses a manifest encoded by encode, the unknown names are ignored.
+func decodeManifest(data []byte) (manifest, error) {
+	var res manifest
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			return manifest{}, fmt.Errorf("line %q: %s", scanner.Text(), errInvalidManifest)
+		}
+
+		var err error
+		switch name {
+		case "version":
+			res.version, err = strconv.Atoi(value)
+		case "checksum":
+			res.checkSum = value
+		case "compress-threshold":
+			res.compressThreshold, err = strconv.Atoi(value)
+		case "encryption":
+			res.encrypted = value != "none"
+		case "max-file-size":
+			res.maxFileSize, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return manifest{}, fmt.Errorf("%s %q: %s", name, value, errInvalidManifest)
+		}
+	}
+	if scanner.Err() != nil {
+		return manifest{}, scanner.Err()
+	}
+	if res.version == 0 {
+		return manifest{}, fmt.Errorf("missing version: %s", errInvalidManifest)
+	}
+
+	return res, nil
+}
+
+// writeFileSync atomically replaces the given file with the given data,
+// by writing it to a synced temporary file that is renamed over the file.
+func writeFileSync(name string, data []byte, perm os.FileMode) error {
+	tmpName := name + tmpExt
+	err := os.WriteFile(tmpName, data, perm)
+	if err != nil {
+		return err
+	}
+	err = syncFile(tmpName)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpName, name)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(path.Dir(name))
+}
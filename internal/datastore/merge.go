@@ -0,0 +1,180 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// mergeManifest is the name of the file recording a committed merge until its old files are deleted.
+	mergeManifest = ".merge"
+	// tmpExt is the extension of the files written by a merge until the merge is committed.
+	tmpExt = ".tmp"
+
+	// mergedEntry prefixes the manifest lines of the files written by the merge.
+	mergedEntry = "merged"
+	// oldEntry prefixes the manifest lines of the files replaced by the merge.
+	oldEntry = "old"
+)
+
+// CommitMerge atomically publishes the given data files written by a Merge append file along with their hint files,
+// replacing the given old files.
+// The merge files are synced then recorded in the merge manifest, which is the commit point of the merge,
+// after which they are renamed to their final names.
+// The old files are left to be deleted by the caller, then FinishMerge removes the manifest.
+// The merge files are removed if the merge fails before its commit point.
+// A merge interrupted before the commit is rolled back by RecoverMerge, and completed if interrupted after it.
+func (d *DataStore) CommitMerge(mergedFiles, oldFiles []string) error {
+	err := d.writeMergeManifest(mergedFiles, oldFiles)
+	if err != nil {
+		d.RollbackMerge()
+		return err
+	}
+
+	return d.publish(mergedFiles)
+}
+
+// RollbackMerge removes the files written by a merge that is not committed.
+func (d *DataStore) RollbackMerge() error {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), tmpExt) {
+			continue
+		}
+		err := os.Remove(path.Join(d.path, entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return syncDir(d.path)
+}
+
+// FinishMerge removes the manifest of the committed merge once all of its old files are deleted.
+func (d *DataStore) FinishMerge() error {
+	err := os.Remove(path.Join(d.path, mergeManifest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return syncDir(d.path)
+}
+
+// RecoverMerge completes or rolls back a merge interrupted by a crash, it must run before the keydir is built.
+// A merge recorded in the manifest is completed by publishing its merge files and deleting its old files,
+// otherwise its files are removed as the old files are still intact.
+func (d *DataStore) RecoverMerge() error {
+	mergedFiles, oldFiles, err := d.readMergeManifest()
+	if os.IsNotExist(err) {
+		return d.RollbackMerge()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", mergeManifest, err)
+	}
+
+	err = d.publish(mergedFiles)
+	if err != nil {
+		return err
+	}
+	for _, file := range oldFiles {
+		err := os.Remove(path.Join(d.path, file))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return d.FinishMerge()
+}
+
+// publish renames the given merge files and their hint files from their temporary names,
+// the files that are already renamed are skipped.
+func (d *DataStore) publish(mergedFiles []string) error {
+	for _, file := range mergedFiles {
+		for _, name := range []string{file, hintName(file)} {
+			err := os.Rename(path.Join(d.path, name+tmpExt), path.Join(d.path, name))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return syncDir(d.path)
+}
+
+// writeMergeManifest syncs the given merge files, then atomically writes the manifest of the merge.
+func (d *DataStore) writeMergeManifest(mergedFiles, oldFiles []string) error {
+	for _, file := range mergedFiles {
+		for _, name := range []string{file, hintName(file)} {
+			err := syncFile(path.Join(d.path, name+tmpExt))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var manifest bytes.Buffer
+	for _, file := range mergedFiles {
+		fmt.Fprintf(&manifest, "%s %s\n", mergedEntry, file)
+	}
+	for _, file := range oldFiles {
+		fmt.Fprintf(&manifest, "%s %s\n", oldEntry, file)
+	}
+
+	return writeFileSync(path.Join(d.path, mergeManifest), manifest.Bytes(), d.config.FileMode)
+}
+
+// readMergeManifest returns the merged and old files recorded in the merge manifest.
+func (d *DataStore) readMergeManifest() ([]string, []string, error) {
+	data, err := os.ReadFile(path.Join(d.path, mergeManifest))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mergedFiles, oldFiles := make([]string, 0), make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		kind, file, ok := strings.Cut(scanner.Text(), " ")
+		switch {
+		case ok && kind == mergedEntry:
+			mergedFiles = append(mergedFiles, file)
+		case ok && kind == oldEntry:
+			oldFiles = append(oldFiles, file)
+		default:
+			return nil, nil, fmt.Errorf("invalid entry %q", scanner.Text())
+		}
+	}
+
+	return mergedFiles, oldFiles, scanner.Err()
+}
+
+// hintName returns the name of the hint file of the given data file.
+func hintName(dataFile string) string {
+	return strings.TrimSuffix(dataFile, ".data") + ".hint"
+}
+
+// syncFile flushes the given file to the disk.
+func syncFile(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
+
+// syncDir flushes the entries of the given directory to the disk, so that the renames and removals are durable.
+func syncDir(dir string) error {
+	return syncFile(dir)
+}
@@ -0,0 +1,201 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// migrateRecordTypes upgrades the datastore files from version 1 by rewriting the data files written
+// before the record types were added, their records are moved to the default bucket
+// and the tombstones get their record type instead of their special value.
+// Every file is rewritten to a temporary file that replaces it once synced, after its hint file is removed
+// since the positions of its records change, so the file is parsed on startup until the next merge.
+// The shared keydir file is removed as well.
+func (d *DataStore) migrateRecordTypes() error {
+	legacyFiles, err := d.legacyFiles()
+	if err != nil {
+		return err
+	}
+	if len(legacyFiles) == 0 {
+		return nil
+	}
+
+	err = os.Remove(path.Join(d.path, "keydir"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, file := range legacyFiles {
+		err := d.migrateFile(file)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+	}
+
+	return syncDir(d.path)
+}
+
+// fileFormat is the format of a data file, detected from its first record.
+type fileFormat int
+
+const (
+	// formatIncomplete is of the files holding only a torn write, too short for any record header or zeroed,
+	// they are left to the recovery on startup as their format does not matter.
+	formatIncomplete fileFormat = iota
+	formatLegacy
+	formatCurrent
+	// formatUnknown is of the files whose first record is complete but valid in no format.
+	formatUnknown
+)
+
+// legacyFiles returns the data files whose first record is written in the legacy format.
+// Returns an error if any data file is written in an unknown format,
+// as it can neither be migrated nor safely handled as a current file.
+func (d *DataStore) legacyFiles() ([]string, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, err
+	}
+
+	legacyFiles := make([]string, 0)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".data") {
+			continue
+		}
+		format, err := d.fileFormat(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		switch format {
+		case formatLegacy:
+			legacyFiles = append(legacyFiles, entry.Name())
+		case formatUnknown:
+			return nil, fmt.Errorf("%s: %s", entry.Name(), errUnknownFormat)
+		}
+	}
+
+	return legacyFiles, nil
+}
+
+// fileFormat detects the format of the given data file from its first record,
+// as the records of a format do not pass the checksum of the other format.
+func (d *DataStore) fileFormat(file string) (fileFormat, error) {
+	f, err := os.Open(path.Join(d.path, file))
+	if err != nil {
+		return formatUnknown, err
+	}
+	defer f.Close()
+
+	current, err := readFirstRec(f, recfmt.DataFileHdrSize, recfmt.DataFileRecLen)
+	if err != nil {
+		return formatUnknown, err
+	}
+	if current != nil {
+		_, _, err := recfmt.ExtractDataFileRec(current)
+		if err == nil {
+			return formatCurrent, nil
+		}
+	}
+
+	legacy, err := readFirstRec(f, recfmt.LegacyDataFileHdrSize, recfmt.LegacyDataFileRecLen)
+	if err != nil {
+		return formatUnknown, err
+	}
+	if legacy != nil {
+		_, _, err := recfmt.ExtractLegacyDataFileRec(legacy)
+		if err == nil {
+			return formatLegacy, nil
+		}
+	}
+
+	// a first record incomplete in every format is only taken as a torn write if it holds no sizes to check,
+	// otherwise its sizes are of no known format either.
+	if current == nil && legacy == nil {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return formatUnknown, err
+		}
+		if len(data) < recfmt.LegacyDataFileHdrSize || bytes.Count(data, []byte{0}) == len(data) {
+			return formatIncomplete, nil
+		}
+	}
+	return formatUnknown, nil
+}
+
+// readFirstRec reads the first record of the given file in a format of the given header size and record length,
+// returns nil if the file ends before the record.
+func readFirstRec(f *os.File, hdrSize int, recLen func(hdr []byte) int64) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < int64(hdrSize) {
+		return nil, nil
+	}
+
+	hdr := make([]byte, hdrSize)
+	_, err = f.ReadAt(hdr, 0)
+	if err != nil {
+		return nil, err
+	}
+	if recLen(hdr) > info.Size() {
+		return nil, nil
+	}
+
+	buff := make([]byte, recLen(hdr))
+	_, err = f.ReadAt(buff, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return buff, nil
+}
+
+// migrateFile rewrites the records of the given legacy data file in the current format.
+// The file is migrated up to its first incomplete or corrupted record, and the bytes from that record on
+// are kept as they are after the migrated records, so that the recovery on startup truncates them
+// or quarantines the file, just as it does for the current files.
+func (d *DataStore) migrateFile(file string) error {
+	data, err := os.ReadFile(path.Join(d.path, file))
+	if err != nil {
+		return err
+	}
+
+	buff := make([]byte, 0, len(data))
+	i := 0
+	for i < len(data) {
+		rec, recLen, err := recfmt.ExtractLegacyDataFileRec(data[i:])
+		if err != nil {
+			break
+		}
+		buff = append(buff, recfmt.CompressDataFileRec(rec.Type, 0, 0, rec.Key, rec.Value, rec.TStamp, 0)...)
+		i += recLen
+	}
+	buff = append(buff, data[i:]...)
+
+	tmpFile := path.Join(d.path, file+tmpExt)
+	err = os.WriteFile(tmpFile, buff, d.config.FileMode)
+	if err != nil {
+		return err
+	}
+	err = syncFile(tmpFile)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path.Join(d.path, hintName(file)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = syncDir(d.path)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, path.Join(d.path, file))
+}
@@ -0,0 +1,66 @@
+package datastore
+
+import (
+	"io"
+	"os"
+	"path"
+)
+
+// quarantineDir is the name of the subdirectory of the datastore holding the copies of the corrupted data files.
+const quarantineDir = "quarantine"
+
+// TruncateFile truncates the given data file to the given size, removing an incomplete record at its end.
+func (d *DataStore) TruncateFile(file string, size int64) error {
+	f, err := os.OpenFile(path.Join(d.path, file), os.O_WRONLY, d.config.FileMode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = f.Truncate(size)
+	if err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// QuarantineFile copies the given corrupted data file to the quarantine directory,
+// then truncates it to the given size keeping its valid records before the corruption.
+// The copy is synced before the file is truncated, so the file is quarantined again if interrupted.
+func (d *DataStore) QuarantineFile(file string, size int64) error {
+	dir := path.Join(d.path, quarantineDir)
+	err := os.MkdirAll(dir, d.config.DirMode)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(path.Join(d.path, file))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path.Join(dir, file), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, d.config.FileMode)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		return err
+	}
+	err = dst.Sync()
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{dir, d.path} {
+		err := syncDir(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.TruncateFile(file, size)
+}
@@ -0,0 +1,159 @@
+package datastore
+
+import (
+	"compress/flate"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+const (
+	// spoolExt is the extension of the temporary files holding the values spooled by SpoolValue.
+	spoolExt = ".spool"
+	// SpoolMemSize is the size up to which a value written from a reader is better read in memory than spooled.
+	SpoolMemSize = 1 << 20
+	// MaxBufferedValue is the largest value read in memory as a whole when it can not be streamed,
+	// such as an encrypted value, which is authenticated as a whole.
+	MaxBufferedValue = 64 << 20
+)
+
+// SpooledValue is a value copied to a temporary file by SpoolValue.
+type SpooledValue struct {
+	file *os.File
+	// Size is the size of the spooled value as stored in the file.
+	Size int64
+	// Flags tells whether the spooled value is compressed.
+	Flags byte
+}
+
+// SpoolValue copies a value of the given size from r to a temporary file in the datastore directory ahead of
+// its write, so that a slow reader does not stall the writes waiting on the caller's lock.
+// The value is compressed while spooled if the compression is enabled and it makes the value smaller.
+// The spooling costs an extra write and read of the value, and the file is removed on startup if left by a crash.
+// The encrypted values must not be spooled, as the file holds the plain value.
+// The spooled value must be released once written.
+func (d *DataStore) SpoolValue(r io.Reader, size int64) (*SpooledValue, error) {
+	f, err := os.CreateTemp(d.path, "*"+spoolExt)
+	if err != nil {
+		return nil, err
+	}
+	spooled := &SpooledValue{file: f, Size: size}
+
+	r = io.LimitReader(r, size)
+	threshold := d.config.CompressThreshold
+	if threshold > 0 && size >= int64(threshold) {
+		err = spooled.compress(r)
+	} else {
+		err = spooled.copy(r)
+	}
+	if err == nil {
+		_, err = spooled.file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spooled.Release()
+		return nil, err
+	}
+
+	return spooled, nil
+}
+
+// copy copies exactly the size of the value from r to the spool file.
+func (spooled *SpooledValue) copy(r io.Reader) error {
+	n, err := io.Copy(spooled.file, r)
+	if err == nil && n < spooled.Size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// compress compresses exactly the size of the value from r to the spool file.
+// The value is copied uncompressed instead if the compression does not make it smaller.
+func (spooled *SpooledValue) compress(r io.Reader) error {
+	w, err := flate.NewWriter(spooled.file, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(w, r)
+	if err == nil && n < spooled.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	compressedSize, err := spooled.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if compressedSize < spooled.Size {
+		spooled.Size, spooled.Flags = compressedSize, recfmt.FlagCompressed
+		return nil
+	}
+
+	// the value is decompressed to a new spool file, as it may not fit in memory.
+	raw, err := os.CreateTemp(path.Dir(spooled.file.Name()), "*"+spoolExt)
+	if err != nil {
+		return err
+	}
+	compressed := spooled.file
+	defer func() {
+		compressed.Close()
+		os.Remove(compressed.Name())
+	}()
+	spooled.file = raw
+
+	_, err = compressed.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return spooled.copy(flate.NewReader(compressed))
+}
+
+// Decoded returns a reader of the spooled value as it was read from the original reader,
+// decompressing it if needed.
+func (spooled *SpooledValue) Decoded() io.Reader {
+	if spooled.Flags&recfmt.FlagCompressed != 0 {
+		return flate.NewReader(spooled.file)
+	}
+
+	return spooled.file
+}
+
+// Read reads the spooled value as stored in the spool file.
+func (spooled *SpooledValue) Read(p []byte) (int, error) {
+	return spooled.file.Read(p)
+}
+
+// Release removes the spool file.
+func (spooled *SpooledValue) Release() {
+	spooled.file.Close()
+	os.Remove(spooled.file.Name())
+}
+
+// removeSpoolFiles removes the temporary files of the values spooled before a crash.
+func (d *DataStore) removeSpoolFiles() error {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), spoolExt) {
+			continue
+		}
+		err := os.Remove(path.Join(d.path, entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package keydir
+
+import (
+	"sort"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// hashKeyDir is a keydir backed by a hash map, it has no ordering of its keys.
+// ordered iterations over it sort the matching keys first.
+type hashKeyDir map[string]recfmt.KeyDirRec
+
+func newHashKeyDir() hashKeyDir {
+	return hashKeyDir{}
+}
+
+func (keyDir hashKeyDir) Get(key string) (recfmt.KeyDirRec, bool) {
+	rec, ok := keyDir[key]
+	return rec, ok
+}
+
+func (keyDir hashKeyDir) Put(key string, rec recfmt.KeyDirRec) {
+	keyDir[key] = rec
+}
+
+func (keyDir hashKeyDir) Delete(key string) {
+	delete(keyDir, key)
+}
+
+func (keyDir hashKeyDir) Len() int {
+	return len(keyDir)
+}
+
+func (keyDir hashKeyDir) Range(fn func(string, recfmt.KeyDirRec) bool) {
+	for key, rec := range keyDir {
+		if !fn(key, rec) {
+			return
+		}
+	}
+}
+
+func (keyDir hashKeyDir) Ascend(start, end string, fn func(string, recfmt.KeyDirRec) bool) {
+	keys := keyDir.sortedKeys(start, end)
+	for _, key := range keys {
+		if !fn(key, keyDir[key]) {
+			return
+		}
+	}
+}
+
+func (keyDir hashKeyDir) Descend(start, end string, fn func(string, recfmt.KeyDirRec) bool) {
+	keys := keyDir.sortedKeys(start, end)
+	for i := len(keys) - 1; i >= 0; i-- {
+		if !fn(keys[i], keyDir[keys[i]]) {
+			return
+		}
+	}
+}
+
+func (keyDir hashKeyDir) Empty() KeyDir {
+	return newHashKeyDir()
+}
+
+// sortedKeys returns the sorted keys in [start, end), an empty end means no upper bound.
+func (keyDir hashKeyDir) sortedKeys(start, end string) []string {
+	keys := make([]string, 0)
+	for key := range keyDir {
+		if key >= start && (end == "" || key < end) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
 	"github.com/Eslam-Nawara/bitcask/internal/sio"
@@ -25,6 +26,11 @@ const (
 	data fileType = 0
 	// hint represents that the file is a hint file.
 	hint fileType = 1
+
+	// HashKeyDir specifies a keydir backed by a hash map, it is fast but has no ordering of its keys.
+	HashKeyDir KeyDirType = 0
+	// OrderedKeyDir specifies a keydir backed by a b-tree, it keeps its keys sorted for prefix and range scans.
+	OrderedKeyDir KeyDirType = 1
 )
 
 type (
@@ -34,58 +40,218 @@ type (
 	// KeyDirPrivacy specifies whether the keydir is private or shared.
 	KeyDirPrivacy int
 
-	// KeyDir represents the map used by the bitcask.
-	KeyDir map[string]recfmt.KeyDirRec
+	// KeyDirType specifies the data structure backing the keydir.
+	KeyDirType int
+
+	// KeyDir represents the in-memory index of the datastore keys used by the bitcask.
+	KeyDir interface {
+		// Get returns the record of the given key if exists.
+		Get(key string) (recfmt.KeyDirRec, bool)
+		// Put sets the record of the given key.
+		Put(key string, rec recfmt.KeyDirRec)
+		// Delete removes the given key.
+		Delete(key string)
+		// Len returns the number of keys.
+		Len() int
+		// Range calls fn for every key in no specific order until fn returns false.
+		Range(fn func(key string, rec recfmt.KeyDirRec) bool)
+		// Ascend calls fn for the keys in [start, end) in ascending order until fn returns false.
+		// An empty end means no upper bound.
+		Ascend(start, end string, fn func(key string, rec recfmt.KeyDirRec) bool)
+		// Descend calls fn for the keys in [start, end) in descending order until fn returns false.
+		// An empty end means no upper bound.
+		Descend(start, end string, fn func(key string, rec recfmt.KeyDirRec) bool)
+		// Empty returns a new empty keydir of the same type.
+		Empty() KeyDir
+	}
+
+	// dataFileEntry represents a parsed data file record and its position in the file.
+	dataFileEntry struct {
+		rec *recfmt.DataFileRec
+		pos int64
+	}
+
+	// buckets holds the keydirs of the datastore buckets mapped by the bucket ids.
+	buckets struct {
+		keyDirType KeyDirType
+		keyDirs    map[uint32]KeyDir
+		encrypter  *recfmt.Encrypter
+	}
+
+	// bucketKey identifies a key within the datastore buckets.
+	bucketKey struct {
+		bucketId uint32
+		key      string
+	}
+
+	// FileStats describes a data file found while building the keydirs.
+	FileStats struct {
+		// Size is the size of the file in bytes.
+		Size int64
+		// TompStones is the number of tombstones in the file.
+		TompStones int
+		// ValidSize is the size of the valid records at the start of the file,
+		// it is less than Size if parsing the file stopped at an invalid record.
+		ValidSize int64
+		// Torn reports whether the data after the valid records is surely an interrupted write,
+		// as it is too short to hold a record header or it is zeroed, rather than a possible corruption.
+		Torn bool
+		// MaxTStamp is the largest timestamp of the records loaded from the file or its hint file,
+		// the writes after opening the datastore are stamped after it.
+		MaxTStamp int64
+	}
 )
 
-func NewKeyDir(dataStorePath string, privacy KeyDirPrivacy) (KeyDir, error) {
-	keyDir := KeyDir{}
+// New creates an empty keydir of the given type.
+func New(keyDirType KeyDirType) KeyDir {
+	if keyDirType == OrderedKeyDir {
+		return newOrderedKeyDir()
+	}
+
+	return newHashKeyDir()
+}
+
+// Split moves the keys of the given keydir in [start, end) to the into keydir, an empty end means no upper bound.
+func Split(keyDir KeyDir, start, end string, into KeyDir) {
+	keys := make([]string, 0)
+	keyDir.Ascend(start, end, func(key string, rec recfmt.KeyDirRec) bool {
+		into.Put(key, rec)
+		keys = append(keys, key)
+		return true
+	})
+
+	for _, key := range keys {
+		keyDir.Delete(key)
+	}
+}
 
-	okay, err := keyDir.buildFromKeydirFile(dataStorePath)
+// NewKeyDir builds the keydirs of the datastore buckets, mapped by the bucket ids.
+// The buckets without any keys have no keydirs.
+// encrypter decrypts the encrypted records, and encrypts the keys of the shared keydir file.
+// Returns the stats of the data files mapped by their names along with the keydirs,
+// the tombstones are only counted in the data files that are parsed as they have no hint files.
+// A parsed data file is only loaded up to its first invalid record, which is reported in its stats
+// for the caller to repair the file.
+// A shared keydir is loaded from the keydir file if it is newer than all the data and hint files,
+// otherwise the files are parsed and the keydir file is written if none of them has an invalid record.
+func NewKeyDir(dataStorePath string, keyDirType KeyDirType, privacy KeyDirPrivacy, fileMode os.FileMode,
+	encrypter *recfmt.Encrypter) (map[uint32]KeyDir, map[string]FileStats, error) {
+	keyDirs := &buckets{keyDirType: keyDirType, keyDirs: make(map[uint32]KeyDir), encrypter: encrypter}
+
+	files, err := readDataFiles(dataStorePath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	if okay {
-		return keyDir, nil
+
+	if privacy == SharedKeyDir {
+		okay, err := buildFromKeydirFile(keyDirs, dataStorePath, files)
+		if err != nil {
+			return nil, nil, err
+		}
+		if okay {
+			return keyDirs.keyDirs, files, nil
+		}
 	}
 
-	err = keyDir.buildFromDataStoreFiles(dataStorePath)
+	err = buildFromDataStoreFiles(keyDirs, dataStorePath, files)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	for _, keyDir := range keyDirs.keyDirs {
+		removeExpired(keyDir)
 	}
 
-	if privacy == SharedKeyDir {
-		keyDir.share(dataStorePath)
+	if privacy == SharedKeyDir && isIntact(files) {
+		share(keyDirs, dataStorePath, fileMode)
 	}
 
-	return keyDir, nil
+	return keyDirs.keyDirs, files, nil
 }
 
-func (keyDir KeyDir) buildFromKeydirFile(dataStorePath string) (bool, error) {
-	data, err := os.ReadFile(path.Join(dataStorePath, keyDirFile))
+// readDataFiles returns the stats of the data files of the datastore holding their sizes.
+func readDataFiles(dataStorePath string) (map[string]FileStats, error) {
+	entries, err := os.ReadDir(dataStorePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
+		return nil, err
+	}
+
+	files := make(map[string]FileStats)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".data") {
+			continue
 		}
-		return false, err
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files[entry.Name()] = FileStats{Size: info.Size(), ValidSize: info.Size()}
+	}
+
+	return files, nil
+}
+
+// of returns the keydir of the given bucket, creating it if not exists.
+func (keyDirs *buckets) of(bucketId uint32) KeyDir {
+	keyDir, ok := keyDirs.keyDirs[bucketId]
+	if !ok {
+		keyDir = New(keyDirs.keyDirType)
+		keyDirs.keyDirs[bucketId] = keyDir
 	}
 
-	okay, err := isOld(dataStorePath)
-	if err != nil || !okay {
+	return keyDir
+}
+
+// buildFromKeydirFile loads the keydirs from the keydir file if it is newer than all the data and hint files.
+// The keydir file is only written when none of the data files has an invalid record, and the data files
+// are not changed since, so their stats are kept as they are.
+// Returns false if the keydir file is not loaded, leaving the keydirs and the stats unchanged,
+// so that the caller parses the data files instead.
+func buildFromKeydirFile(keyDirs *buckets, dataStorePath string, stats map[string]FileStats) (bool, error) {
+	fresh, err := isFresh(dataStorePath)
+	if err != nil || !fresh {
+		return false, nil
+	}
+	data, err := os.ReadFile(path.Join(dataStorePath, keyDirFile))
+	if err != nil {
 		return false, nil
 	}
 
+	loaded := &buckets{keyDirType: keyDirs.keyDirType, keyDirs: make(map[uint32]KeyDir), encrypter: keyDirs.encrypter}
+	tStamps := make(map[string]int64)
+	now := time.Now().UnixMicro()
 	n := len(data)
 	for i := 0; i < n; {
-		key, rec, recLen := recfmt.ExtractKeyDirRec(data[i:])
-		keyDir[key] = rec
+		sealedKey, keyId, rec, recLen, err := recfmt.ExtractKeyDirRec(data[i:])
+		if err != nil {
+			return false, nil
+		}
+		// a record outside of its data file means the keydir file does not describe the data files.
+		file, ok := stats[rec.FileId]
+		if !ok || rec.ValuePos < 0 || rec.ValuePos+recfmt.DataFileHdrSize+int64(rec.ValueSize) > file.Size {
+			return false, nil
+		}
+		key, err := keyDirs.encrypter.OpenKey(keyId, sealedKey)
+		if err != nil {
+			return false, err
+		}
+		if !rec.IsExpired(now) {
+			loaded.of(rec.BucketId).Put(string(key), rec)
+		}
+		if rec.TStamp > tStamps[rec.FileId] {
+			tStamps[rec.FileId] = rec.TStamp
+		}
 		i += recLen
 	}
 
+	keyDirs.keyDirs = loaded.keyDirs
+	for fileName, tStamp := range tStamps {
+		stampFile(stats, fileName, tStamp)
+	}
+
 	return true, nil
 }
 
-func (keyDir KeyDir) buildFromDataStoreFiles(dataStorePath string) error {
+func buildFromDataStoreFiles(keyDirs *buckets, dataStorePath string, stats map[string]FileStats) error {
 	dataStore, err := os.Open(dataStorePath)
 	if err != nil {
 		return err
@@ -97,11 +263,16 @@ func (keyDir KeyDir) buildFromDataStoreFiles(dataStorePath string) error {
 	}
 	fileNames := extractFileNames(files)
 
-	err = keyDir.parseFiles(dataStorePath, categorizeFiles(fileNames))
+	tompStones := make(map[bucketKey]bool)
+	err = parseFiles(keyDirs, dataStorePath, categorizeFiles(fileNames), tompStones, stats)
 	if err != nil {
 		return err
 	}
 
+	for key := range tompStones {
+		keyDirs.of(key.bucketId).Delete(key.key)
+	}
+
 	return nil
 }
 
@@ -115,48 +286,89 @@ func extractFileNames(files []fs.FileInfo) []string {
 	return fileNames
 }
 
-func (keyDir KeyDir) share(dataStorePath string) error {
+func share(keyDirs *buckets, dataStorePath string, fileMode os.FileMode) error {
 	flags := os.O_CREATE | os.O_RDWR | os.O_TRUNC
-	perm := os.FileMode(0666)
-	file, err := sio.OpenFile(path.Join(dataStorePath, "keydir"), flags, perm)
+	file, err := sio.OpenFile(path.Join(dataStorePath, "keydir"), flags, fileMode)
 	if err != nil {
 		return err
 	}
 
-	for key, rec := range keyDir {
-		buff := recfmt.CompressKeyDirRec(key, rec)
-		_, err := file.Write(buff)
+	for _, keyDir := range keyDirs.keyDirs {
+		keyDir.Range(func(key string, rec recfmt.KeyDirRec) bool {
+			keyId, sealedKey, sealErr := keyDirs.encrypter.SealKey([]byte(key))
+			if sealErr != nil {
+				err = sealErr
+				return false
+			}
+			_, err = file.Write(recfmt.CompressKeyDirRec(sealedKey, keyId, rec))
+			return err == nil
+		})
 		if err != nil {
 			return err
 		}
 	}
+
 	return nil
 }
 
-func isOld(dataStorePath string) (bool, error) {
-	dataStoreStat, err := os.Stat(dataStorePath)
+// isFresh reports whether the keydir file exists and is newer than all the data and hint files,
+// so that none of them has changed since it was written.
+func isFresh(dataStorePath string) (bool, error) {
+	keydirStat, err := os.Stat(path.Join(dataStorePath, keyDirFile))
 	if err != nil {
 		return false, err
 	}
 
-	keydirStat, err := os.Stat(path.Join(dataStorePath, "keydir"))
+	entries, err := os.ReadDir(dataStorePath)
 	if err != nil {
 		return false, err
 	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".data") && !strings.HasSuffix(entry.Name(), ".hint") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return false, err
+		}
+		if !info.ModTime().Before(keydirStat.ModTime()) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// isIntact reports whether all the given data files are valid up to their end.
+func isIntact(stats map[string]FileStats) bool {
+	for _, file := range stats {
+		if file.ValidSize < file.Size {
+			return false
+		}
+	}
 
-	return keydirStat.ModTime().Before(dataStoreStat.ModTime()), nil
+	return true
 }
 
-func (keyDir KeyDir) parseFiles(dataStorePath string, files map[string]fileType) error {
+// parseFiles parses the given files into the keydir.
+// tompStones collects the keys whose newest record is a tombstone, their records are kept in the keydir
+// while parsing to shadow the older records of the same keys, then they are removed by the caller.
+// the tombstones of the parsed data files are counted in stats.
+// a hint file that ends in the middle of a record is skipped and its data file is parsed instead.
+func parseFiles(keyDirs *buckets, dataStorePath string, files map[string]fileType, tompStones map[bucketKey]bool,
+	stats map[string]FileStats) error {
 	for FileName, fType := range files {
 		switch fType {
 		case data:
-			err := keyDir.parseDataFile(dataStorePath, FileName)
+			err := parseDataFile(keyDirs, dataStorePath, FileName, tompStones, stats)
 			if err != nil {
 				return err
 			}
 		case hint:
-			err := keyDir.parseHintFile(dataStorePath, FileName)
+			err := parseHintFile(keyDirs, dataStorePath, FileName, tompStones, stats)
+			if err == recfmt.ErrIncompleteRec {
+				err = parseDataFile(keyDirs, dataStorePath, hintDataFile(FileName), tompStones, stats)
+			}
 			if err != nil {
 				return err
 			}
@@ -166,51 +378,183 @@ func (keyDir KeyDir) parseFiles(dataStorePath string, files map[string]fileType)
 	return nil
 }
 
-func (keyDir KeyDir) parseDataFile(dataStorePath, fileName string) error {
+func parseDataFile(keyDirs *buckets, dataStorePath, fileName string, tompStones map[bucketKey]bool,
+	stats map[string]FileStats) error {
 	data, err := os.ReadFile(path.Join(dataStorePath, fileName))
 	if err != nil {
 		return err
 	}
 
+	// batch holds the records of the write batch being parsed until its commit marker is found,
+	// the records of batches without a commit marker are skipped.
+	var batch []dataFileEntry
+	var batchPos int64
+	inBatch := false
+	fileStats := stats[fileName]
+
 	n := len(data)
 	for i := 0; i < n; {
 		rec, recLen, err := recfmt.ExtractDataFileRec(data[i:])
+		if err == recfmt.ErrIncompleteRec || err == recfmt.ErrDataCorruption {
+			// the valid records end before the batch being parsed, as it can not be committed anymore.
+			fileStats.ValidSize = int64(i)
+			if inBatch {
+				fileStats.ValidSize = batchPos
+			}
+			fileStats.Torn = isTornTail(data[fileStats.ValidSize:])
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", fileName, err)
+		}
+		err = keyDirs.encrypter.Decrypt(rec)
 		if err != nil {
 			return err
 		}
+		if rec.TStamp > fileStats.MaxTStamp {
+			fileStats.MaxTStamp = rec.TStamp
+		}
 
-		old, exists := keyDir[rec.Key]
-		if !exists || old.TStamp < rec.TStamp {
-			keyDir[rec.Key] = recfmt.KeyDirRec{
-				FileId:    fileName,
-				ValuePos:  uint32(i),
-				ValueSize: rec.ValueSize,
-				TStamp:    rec.TStamp,
+		switch {
+		case rec.IsBatchBegin():
+			batch, inBatch, batchPos = batch[:0], true, int64(i)
+		case rec.IsBatchCommit():
+			for _, entry := range batch {
+				update(keyDirs, fileName, entry, tompStones)
+				fileStats.TompStones += countTompStone(entry.rec)
 			}
+			batch, inBatch = batch[:0], false
+		case inBatch:
+			batch = append(batch, dataFileEntry{rec: rec, pos: int64(i)})
+		default:
+			update(keyDirs, fileName, dataFileEntry{rec: rec, pos: int64(i)}, tompStones)
+			fileStats.TompStones += countTompStone(rec)
 		}
-		i += int(recLen)
+		i += recLen
 	}
+	stats[fileName] = fileStats
 
 	return nil
 }
 
-func (keyDir KeyDir) parseHintFile(dataStorePath, fileName string) error {
+// isTornTail reports whether the given end of a data file dropped by the recovery is surely left by an interrupted
+// write, so that it can be truncated without a copy: it is too short to hold a record header, or it is zeroed.
+// Any other invalid end may be a corrupted size hiding the valid records after it, so it is not taken as torn.
+func isTornTail(rest []byte) bool {
+	if len(rest) < recfmt.DataFileHdrSize {
+		return true
+	}
+
+	for _, b := range rest {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// stampFile raises the largest timestamp in the stats of the given data file to the given one.
+func stampFile(stats map[string]FileStats, fileName string, tStamp int64) {
+	fileStats, ok := stats[fileName]
+	if ok && tStamp > fileStats.MaxTStamp {
+		fileStats.MaxTStamp = tStamp
+		stats[fileName] = fileStats
+	}
+}
+
+func countTompStone(rec *recfmt.DataFileRec) int {
+	if rec.IsTompStone() {
+		return 1
+	}
+
+	return 0
+}
+
+// update points the key of the given entry to it if it is newer than the existing one.
+func update(keyDirs *buckets, fileName string, entry dataFileEntry, tompStones map[bucketKey]bool) {
+	keyDir := keyDirs.of(entry.rec.BucketId)
+	key := string(entry.rec.Key)
+	old, exists := keyDir.Get(key)
+	if !exists || old.TStamp < entry.rec.TStamp {
+		keyDir.Put(key, recfmt.KeyDirRec{
+			FileId:    fileName,
+			ValuePos:  entry.pos,
+			ValueSize: entry.rec.ValueSize,
+			TStamp:    entry.rec.TStamp,
+			Expiry:    entry.rec.Expiry,
+			BucketId:  entry.rec.BucketId,
+		})
+		if entry.rec.IsTompStone() {
+			tompStones[bucketKey{entry.rec.BucketId, key}] = true
+		} else {
+			delete(tompStones, bucketKey{entry.rec.BucketId, key})
+		}
+	}
+}
+
+// removeExpired removes the expired records from the keydir.
+// it runs after the keydir is built, so that the expired records still shadow the older records of their keys.
+func removeExpired(keyDir KeyDir) {
+	expired := make([]string, 0)
+
+	now := time.Now().UnixMicro()
+	keyDir.Range(func(key string, rec recfmt.KeyDirRec) bool {
+		if rec.IsExpired(now) {
+			expired = append(expired, key)
+		}
+		return true
+	})
+
+	for _, key := range expired {
+		keyDir.Delete(key)
+	}
+}
+
+// parseHintFile parses the given hint file into the keydir.
+// Returns recfmt.ErrIncompleteRec without changing the keydir if the file ends in the middle of a record.
+func parseHintFile(keyDirs *buckets, dataStorePath, fileName string, tompStones map[bucketKey]bool,
+	stats map[string]FileStats) error {
 	data, err := os.ReadFile(path.Join(dataStorePath, fileName))
 	if err != nil {
 		return err
 	}
 
+	keys := make([]string, 0)
+	recs := make([]recfmt.KeyDirRec, 0)
 	n := len(data)
 	for i := 0; i < n; {
-		key, rec, recLen := recfmt.ExtractHintFileRec(data[i:])
-		rec.FileId = fmt.Sprintf("%s.data", strings.Trim(fileName, ".hint"))
-		keyDir[key] = rec
+		sealedKey, keyId, rec, recLen, err := recfmt.ExtractHintFileRec(data[i:])
+		if err != nil {
+			return err
+		}
+		plainKey, err := keyDirs.encrypter.OpenKey(keyId, sealedKey)
+		if err != nil {
+			return err
+		}
+		rec.FileId = hintDataFile(fileName)
+		keys = append(keys, string(plainKey))
+		recs = append(recs, rec)
 		i += recLen
 	}
 
+	for i, key := range keys {
+		rec := recs[i]
+		stampFile(stats, rec.FileId, rec.TStamp)
+		keyDir := keyDirs.of(rec.BucketId)
+		if old, exists := keyDir.Get(key); !exists || old.TStamp < rec.TStamp {
+			keyDir.Put(key, rec)
+			delete(tompStones, bucketKey{rec.BucketId, key})
+		}
+	}
+
 	return nil
 }
 
+// hintDataFile returns the name of the data file of the given hint file.
+func hintDataFile(hintFile string) string {
+	return fmt.Sprintf("%s.data", strings.Trim(hintFile, ".hint"))
+}
+
 func categorizeFiles(allFiles []string) map[string]fileType {
 	res := make(map[string]fileType)
 
@@ -0,0 +1,187 @@
+package keydir
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// writeDataFile writes a data file of the given name holding the given keys, each valued by its key
+// and stamped by its position, and returns their keydir records.
+func writeDataFile(t *testing.T, dir, name string, keys ...string) map[string]recfmt.KeyDirRec {
+	t.Helper()
+
+	recs := make(map[string]recfmt.KeyDirRec)
+	data := make([]byte, 0)
+	for i, key := range keys {
+		recs[key] = recfmt.KeyDirRec{FileId: name, ValuePos: int64(len(data)), ValueSize: uint32(len(key)), TStamp: int64(i + 1)}
+		data = append(data, recfmt.CompressDataFileRec(recfmt.RecPut, 0, 0, []byte(key), []byte(key), int64(i+1), 0)...)
+	}
+	if err := os.WriteFile(path.Join(dir, name), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return recs
+}
+
+// appendFile appends the given data to the given file without changing its modification time.
+func appendFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// backdate sets the modification time of the given file an hour back, so that the keydir file written
+// after it is surely newer on file systems with a coarse time resolution.
+func backdate(t *testing.T, name string) {
+	t.Helper()
+
+	earlier := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(name, earlier, earlier); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkKeyDir builds the keydir of the given datastore and checks that it holds exactly the given records.
+func checkKeyDir(t *testing.T, dir string, privacy KeyDirPrivacy, want map[string]recfmt.KeyDirRec) map[string]FileStats {
+	t.Helper()
+
+	keyDirs, stats, err := NewKeyDir(dir, HashKeyDir, privacy, 0o644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDir := keyDirs[0]
+	if keyDir.Len() != len(want) {
+		t.Fatalf("got %d keys, want %d", keyDir.Len(), len(want))
+	}
+	for key, rec := range want {
+		if got, ok := keyDir.Get(key); !ok || got != rec {
+			t.Fatalf("%s: got %+v, want %+v", key, got, rec)
+		}
+	}
+
+	return stats
+}
+
+func TestKeyDirFile(t *testing.T) {
+	dir := t.TempDir()
+	want := writeDataFile(t, dir, "1.data", "a", "b", "c")
+	backdate(t, path.Join(dir, "1.data"))
+	checkKeyDir(t, dir, SharedKeyDir, want)
+	if _, err := os.Stat(path.Join(dir, keyDirFile)); err != nil {
+		t.Fatalf("the keydir file is not written: %s", err)
+	}
+
+	// a record appended without changing the modification time of the data file is not in the keydir file,
+	// so it is only found when the data file is parsed.
+	appendFile(t, path.Join(dir, "1.data"), recfmt.CompressDataFileRec(recfmt.RecPut, 0, 0, []byte("d"), []byte("d"), 4, 0))
+	stats := checkKeyDir(t, dir, SharedKeyDir, want)
+	if stats["1.data"].MaxTStamp != 3 {
+		t.Fatalf("got the largest timestamp %d, want 3", stats["1.data"].MaxTStamp)
+	}
+
+	withAppended := writeDataFile(t, t.TempDir(), "1.data", "a", "b", "c", "d")
+	checkKeyDir(t, dir, PrivateKeyDir, withAppended)
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path.Join(dir, "1.data"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	checkKeyDir(t, dir, SharedKeyDir, withAppended)
+}
+
+func TestKeyDirFileCut(t *testing.T) {
+	// the keydir file is cut in the key of its last record, then in its header.
+	for _, cut := range []int{1, len("c") + 1, recfmt.DataFileHdrSize} {
+		dir := t.TempDir()
+		want := writeDataFile(t, dir, "1.data", "a", "b", "c")
+		backdate(t, path.Join(dir, "1.data"))
+		checkKeyDir(t, dir, SharedKeyDir, want)
+
+		name := path.Join(dir, keyDirFile)
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(name, info.Size()-int64(cut)); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+		checkKeyDir(t, dir, SharedKeyDir, want)
+	}
+}
+
+func TestKeyDirFileDamagedData(t *testing.T) {
+	dir := t.TempDir()
+	want := writeDataFile(t, dir, "1.data", "a", "b")
+	appendFile(t, path.Join(dir, "1.data"), []byte("not a record"))
+
+	for i := 0; i < 2; i++ {
+		stats := checkKeyDir(t, dir, SharedKeyDir, want)
+		if file := stats["1.data"]; file.ValidSize >= file.Size {
+			t.Fatalf("got a valid size of %d out of %d bytes, want the damage reported", file.ValidSize, file.Size)
+		}
+		if _, err := os.Stat(path.Join(dir, keyDirFile)); err == nil {
+			t.Fatal("the keydir file of damaged data files is written")
+		}
+	}
+}
+
+func TestHintFileCut(t *testing.T) {
+	dir := t.TempDir()
+	want := writeDataFile(t, dir, "1.data", "a", "b", "c")
+	hint := make([]byte, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		hint = append(hint, recfmt.CompressHintFileRec([]byte(key), 0, want[key])...)
+	}
+
+	for _, cut := range []int{1, len("c") + 1} {
+		if err := os.WriteFile(path.Join(dir, "1.hint"), hint[:len(hint)-cut], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		stats := checkKeyDir(t, dir, PrivateKeyDir, want)
+		if stats["1.data"].MaxTStamp != 3 {
+			t.Fatalf("cut %d: got the largest timestamp %d, want 3", cut, stats["1.data"].MaxTStamp)
+		}
+	}
+}
+
+func TestKeyDirRecFileId(t *testing.T) {
+	for _, fileId := range []string{"1.data", fmt.Sprintf("%d.data", time.Now().UnixMicro())} {
+		rec := recfmt.KeyDirRec{FileId: fileId, ValuePos: 10, ValueSize: 3, TStamp: 7, BucketId: 2}
+		buff := recfmt.CompressKeyDirRec([]byte("key"), 0, rec)
+		key, _, got, n, err := recfmt.ExtractKeyDirRec(buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(key) != "key" || got != rec || n != len(buff) {
+			t.Fatalf("got %q %+v of %d bytes, want %q %+v of %d bytes", key, got, n, "key", rec, len(buff))
+		}
+		for cut := 1; cut <= len(buff); cut++ {
+			if _, _, _, _, err := recfmt.ExtractKeyDirRec(buff[:len(buff)-cut]); err != recfmt.ErrIncompleteRec {
+				t.Fatalf("cut %d: got error %v, want %v", cut, err, recfmt.ErrIncompleteRec)
+			}
+		}
+	}
+}
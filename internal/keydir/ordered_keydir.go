@@ -0,0 +1,334 @@
+package keydir
+
+import (
+	"sort"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// btreeDegree is the minimum degree of the ordered keydir b-tree,
+// every node other than the root holds between btreeDegree-1 and 2*btreeDegree-1 items.
+const btreeDegree = 32
+
+type (
+	// orderedKeyDir is a keydir backed by an in-memory b-tree that keeps its keys sorted.
+	orderedKeyDir struct {
+		root   *btreeNode
+		length int
+	}
+
+	// btreeNode represents a single node of the b-tree, leaf nodes have no children.
+	btreeNode struct {
+		items    []btreeItem
+		children []*btreeNode
+	}
+
+	// btreeItem represents a single key and its record in the b-tree.
+	btreeItem struct {
+		key string
+		rec recfmt.KeyDirRec
+	}
+)
+
+func newOrderedKeyDir() *orderedKeyDir {
+	return &orderedKeyDir{}
+}
+
+func (keyDir *orderedKeyDir) Get(key string) (recfmt.KeyDirRec, bool) {
+	for node := keyDir.root; node != nil; {
+		i, found := node.find(key)
+		if found {
+			return node.items[i].rec, true
+		}
+		if node.isLeaf() {
+			break
+		}
+		node = node.children[i]
+	}
+
+	return recfmt.KeyDirRec{}, false
+}
+
+func (keyDir *orderedKeyDir) Put(key string, rec recfmt.KeyDirRec) {
+	item := btreeItem{key: key, rec: rec}
+
+	if keyDir.root == nil {
+		keyDir.root = &btreeNode{items: []btreeItem{item}}
+		keyDir.length++
+		return
+	}
+
+	if len(keyDir.root.items) == 2*btreeDegree-1 {
+		oldRoot := keyDir.root
+		keyDir.root = &btreeNode{children: []*btreeNode{oldRoot}}
+		keyDir.root.splitChild(0)
+	}
+
+	if keyDir.root.insert(item) {
+		keyDir.length++
+	}
+}
+
+func (keyDir *orderedKeyDir) Delete(key string) {
+	if keyDir.root == nil {
+		return
+	}
+
+	if keyDir.root.remove(key) {
+		keyDir.length--
+	}
+
+	if len(keyDir.root.items) == 0 {
+		if keyDir.root.isLeaf() {
+			keyDir.root = nil
+		} else {
+			keyDir.root = keyDir.root.children[0]
+		}
+	}
+}
+
+func (keyDir *orderedKeyDir) Len() int {
+	return keyDir.length
+}
+
+func (keyDir *orderedKeyDir) Range(fn func(string, recfmt.KeyDirRec) bool) {
+	keyDir.Ascend("", "", fn)
+}
+
+func (keyDir *orderedKeyDir) Ascend(start, end string, fn func(string, recfmt.KeyDirRec) bool) {
+	if keyDir.root != nil {
+		keyDir.root.ascend(start, end, fn)
+	}
+}
+
+func (keyDir *orderedKeyDir) Descend(start, end string, fn func(string, recfmt.KeyDirRec) bool) {
+	if keyDir.root != nil {
+		keyDir.root.descend(start, end, fn)
+	}
+}
+
+func (keyDir *orderedKeyDir) Empty() KeyDir {
+	return newOrderedKeyDir()
+}
+
+func (node *btreeNode) isLeaf() bool {
+	return len(node.children) == 0
+}
+
+// find returns the index of the first item whose key is not less than the given key,
+// and whether that item has the given key.
+func (node *btreeNode) find(key string) (int, bool) {
+	i := sort.Search(len(node.items), func(i int) bool {
+		return node.items[i].key >= key
+	})
+
+	return i, i < len(node.items) && node.items[i].key == key
+}
+
+// splitChild splits the full child at index i around its median item, which moves up to the node.
+func (node *btreeNode) splitChild(i int) {
+	child := node.children[i]
+	median := child.items[btreeDegree-1]
+
+	right := &btreeNode{
+		items: append([]btreeItem{}, child.items[btreeDegree:]...),
+	}
+	child.items = child.items[:btreeDegree-1]
+	if !child.isLeaf() {
+		right.children = append([]*btreeNode{}, child.children[btreeDegree:]...)
+		child.children = child.children[:btreeDegree]
+	}
+
+	node.items = append(node.items, btreeItem{})
+	copy(node.items[i+1:], node.items[i:])
+	node.items[i] = median
+
+	node.children = append(node.children, nil)
+	copy(node.children[i+2:], node.children[i+1:])
+	node.children[i+1] = right
+}
+
+// insert inserts the item into the subtree of a non full node, or replaces the item with the same key.
+// returns true if the item is newly inserted.
+func (node *btreeNode) insert(item btreeItem) bool {
+	i, found := node.find(item.key)
+	if found {
+		node.items[i] = item
+		return false
+	}
+
+	if node.isLeaf() {
+		node.items = append(node.items, btreeItem{})
+		copy(node.items[i+1:], node.items[i:])
+		node.items[i] = item
+		return true
+	}
+
+	if len(node.children[i].items) == 2*btreeDegree-1 {
+		node.splitChild(i)
+		switch {
+		case item.key == node.items[i].key:
+			node.items[i] = item
+			return false
+		case item.key > node.items[i].key:
+			i++
+		}
+	}
+
+	return node.children[i].insert(item)
+}
+
+// remove removes the item with the given key from the subtree of the node.
+// every node it descends into is first made to hold at least btreeDegree items,
+// so the removal never leaves a node under its minimum size.
+// returns true if the item existed.
+func (node *btreeNode) remove(key string) bool {
+	i, found := node.find(key)
+
+	if node.isLeaf() {
+		if found {
+			node.items = append(node.items[:i], node.items[i+1:]...)
+		}
+		return found
+	}
+
+	if found {
+		switch {
+		case len(node.children[i].items) >= btreeDegree:
+			pred := node.children[i].max()
+			node.items[i] = pred
+			return node.children[i].remove(pred.key)
+		case len(node.children[i+1].items) >= btreeDegree:
+			succ := node.children[i+1].min()
+			node.items[i] = succ
+			return node.children[i+1].remove(succ.key)
+		default:
+			node.mergeChildren(i)
+			return node.children[i].remove(key)
+		}
+	}
+
+	if len(node.children[i].items) < btreeDegree {
+		i = node.growChild(i)
+	}
+
+	return node.children[i].remove(key)
+}
+
+// growChild makes the child at index i hold at least btreeDegree items by borrowing an item
+// from one of its siblings or merging it with one of them.
+// returns the index of the grown child.
+func (node *btreeNode) growChild(i int) int {
+	child := node.children[i]
+
+	if i > 0 && len(node.children[i-1].items) >= btreeDegree {
+		left := node.children[i-1]
+		child.items = append([]btreeItem{node.items[i-1]}, child.items...)
+		node.items[i-1] = left.items[len(left.items)-1]
+		left.items = left.items[:len(left.items)-1]
+		if !left.isLeaf() {
+			child.children = append([]*btreeNode{left.children[len(left.children)-1]}, child.children...)
+			left.children = left.children[:len(left.children)-1]
+		}
+		return i
+	}
+
+	if i < len(node.children)-1 && len(node.children[i+1].items) >= btreeDegree {
+		right := node.children[i+1]
+		child.items = append(child.items, node.items[i])
+		node.items[i] = right.items[0]
+		right.items = append(right.items[:0], right.items[1:]...)
+		if !right.isLeaf() {
+			child.children = append(child.children, right.children[0])
+			right.children = append(right.children[:0], right.children[1:]...)
+		}
+		return i
+	}
+
+	if i == len(node.children)-1 {
+		i--
+	}
+	node.mergeChildren(i)
+
+	return i
+}
+
+// mergeChildren merges the child at index i+1 and the item at index i into the child at index i.
+func (node *btreeNode) mergeChildren(i int) {
+	left, right := node.children[i], node.children[i+1]
+
+	left.items = append(left.items, node.items[i])
+	left.items = append(left.items, right.items...)
+	left.children = append(left.children, right.children...)
+
+	node.items = append(node.items[:i], node.items[i+1:]...)
+	node.children = append(node.children[:i+1], node.children[i+2:]...)
+}
+
+func (node *btreeNode) min() btreeItem {
+	for !node.isLeaf() {
+		node = node.children[0]
+	}
+
+	return node.items[0]
+}
+
+func (node *btreeNode) max() btreeItem {
+	for !node.isLeaf() {
+		node = node.children[len(node.children)-1]
+	}
+
+	return node.items[len(node.items)-1]
+}
+
+// ascend calls fn for the items of the subtree in [start, end) in ascending order, an empty end means no upper bound.
+// returns false if the iteration is stopped.
+func (node *btreeNode) ascend(start, end string, fn func(string, recfmt.KeyDirRec) bool) bool {
+	i, _ := node.find(start)
+	for ; i <= len(node.items); i++ {
+		if !node.isLeaf() && !node.children[i].ascend(start, end, fn) {
+			return false
+		}
+		if i == len(node.items) {
+			break
+		}
+
+		item := node.items[i]
+		if end != "" && item.key >= end {
+			return false
+		}
+		if !fn(item.key, item.rec) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// descend calls fn for the items of the subtree in [start, end) in descending order, an empty end means no upper bound.
+// returns false if the iteration is stopped.
+func (node *btreeNode) descend(start, end string, fn func(string, recfmt.KeyDirRec) bool) bool {
+	i := len(node.items)
+	if end != "" {
+		i, _ = node.find(end)
+	}
+
+	for ; i >= 0; i-- {
+		if !node.isLeaf() && !node.children[i].descend(start, end, fn) {
+			return false
+		}
+		if i == 0 {
+			break
+		}
+
+		item := node.items[i-1]
+		if item.key < start {
+			return false
+		}
+		if !fn(item.key, item.rec) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,146 @@
+package keydir
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// sortedKeys returns the keys of the given map in [start, end) in ascending order, an empty end means no upper bound.
+func sortedKeys(model map[string]recfmt.KeyDirRec, start, end string) []string {
+	keys := make([]string, 0, len(model))
+	for key := range model {
+		if key >= start && (end == "" || key < end) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// collect returns the keys visited by the given iteration, stopping after limit keys if limit is positive.
+func collect(t *testing.T, model map[string]recfmt.KeyDirRec, limit int,
+	iterate func(fn func(string, recfmt.KeyDirRec) bool)) []string {
+	t.Helper()
+
+	keys := make([]string, 0)
+	iterate(func(key string, rec recfmt.KeyDirRec) bool {
+		if rec != model[key] {
+			t.Fatalf("key %q: got record %+v, want %+v", key, rec, model[key])
+		}
+		keys = append(keys, key)
+		return limit <= 0 || len(keys) < limit
+	})
+
+	return keys
+}
+
+func equalKeys(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func reversed(keys []string) []string {
+	res := make([]string, len(keys))
+	for i, key := range keys {
+		res[len(keys)-1-i] = key
+	}
+
+	return res
+}
+
+func truncated(keys []string, limit int) []string {
+	if limit > 0 && len(keys) > limit {
+		return keys[:limit]
+	}
+
+	return keys
+}
+
+func TestOrderedKeyDirRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	randomKey := func() string {
+		// a small key space makes the overwrites and the deletes of existing keys frequent.
+		return fmt.Sprintf("key%04d", rnd.Intn(20000))
+	}
+
+	keyDir := newOrderedKeyDir()
+	model := make(map[string]recfmt.KeyDirRec)
+
+	for op := 0; op < 100000; op++ {
+		key := randomKey()
+		// deletes are less frequent than puts, so the tree grows deep enough to split and merge its nodes.
+		if rnd.Intn(3) == 0 {
+			keyDir.Delete(key)
+			delete(model, key)
+		} else {
+			rec := recfmt.KeyDirRec{FileId: "1", ValuePos: int64(op), ValueSize: uint32(rnd.Intn(100))}
+			keyDir.Put(key, rec)
+			model[key] = rec
+		}
+
+		if keyDir.Len() != len(model) {
+			t.Fatalf("op %d: got length %d, want %d", op, keyDir.Len(), len(model))
+		}
+		if op%1000 != 0 {
+			continue
+		}
+
+		for key, rec := range model {
+			got, ok := keyDir.Get(key)
+			if !ok || got != rec {
+				t.Fatalf("op %d: get %q: got %+v %v, want %+v", op, key, got, ok, rec)
+			}
+		}
+		if _, ok := keyDir.Get("missing"); ok {
+			t.Fatalf("op %d: get of a missing key succeeded", op)
+		}
+
+		start, end := randomKey(), randomKey()
+		if start > end {
+			start, end = end, start
+		}
+		bounds := [][2]string{{"", ""}, {start, ""}, {"", end}, {start, end}, {end, start}, {start, start}}
+		for _, bound := range bounds {
+			want := sortedKeys(model, bound[0], bound[1])
+
+			for _, limit := range []int{0, 1, 10} {
+				got := collect(t, model, limit, func(fn func(string, recfmt.KeyDirRec) bool) {
+					keyDir.Ascend(bound[0], bound[1], fn)
+				})
+				if !equalKeys(got, truncated(want, limit)) {
+					t.Fatalf("op %d: ascend [%q, %q) limit %d: got %v, want %v",
+						op, bound[0], bound[1], limit, got, truncated(want, limit))
+				}
+
+				got = collect(t, model, limit, func(fn func(string, recfmt.KeyDirRec) bool) {
+					keyDir.Descend(bound[0], bound[1], fn)
+				})
+				if !equalKeys(got, truncated(reversed(want), limit)) {
+					t.Fatalf("op %d: descend [%q, %q) limit %d: got %v, want %v",
+						op, bound[0], bound[1], limit, got, truncated(reversed(want), limit))
+				}
+			}
+		}
+	}
+
+	// deleting every key must collapse the tree back to empty.
+	for _, key := range sortedKeys(model, "", "") {
+		keyDir.Delete(key)
+	}
+	if keyDir.Len() != 0 || keyDir.root != nil {
+		t.Fatalf("got length %d after deleting every key, want an empty tree", keyDir.Len())
+	}
+}
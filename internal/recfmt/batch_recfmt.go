@@ -0,0 +1,11 @@
+package recfmt
+
+// CompressBatchBeginRec returns the data file record that marks the start of a write batch.
+func CompressBatchBeginRec(tStamp int64) []byte {
+	return CompressDataFileRec(RecBatchBegin, 0, 0, nil, nil, tStamp, 0)
+}
+
+// CompressBatchCommitRec returns the data file record that marks the end of a committed write batch.
+func CompressBatchCommitRec(tStamp int64) []byte {
+	return CompressDataFileRec(RecBatchCommit, 0, 0, nil, nil, tStamp, 0)
+}
@@ -0,0 +1,66 @@
+package recfmt
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// CompressValue compresses the given value with DEFLATE at the given compression level.
+func CompressValue(value []byte, level int) ([]byte, error) {
+	var buff bytes.Buffer
+
+	w, err := flate.NewWriter(&buff, level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(value)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// DecompressValue decompresses the given value compressed by CompressValue.
+func DecompressValue(value []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(value))
+	defer r.Close()
+
+	res, err := io.ReadAll(r)
+	if err != nil {
+		return nil, ErrDataCorruption
+	}
+
+	return res, nil
+}
+
+// decompressReader decompresses a value streamed from a value reader.
+type decompressReader struct {
+	value *valueReader
+	r     io.ReadCloser
+}
+
+func newDecompressReader(value *valueReader) *decompressReader {
+	return &decompressReader{value: value, r: flate.NewReader(value)}
+}
+
+// Read reads the decompressed value, the end of the compressed stream is reported as EOF
+// only after the rest of the stored value is read, so that the record checksum is validated.
+func (reader *decompressReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	if err == io.EOF {
+		_, err = io.Copy(io.Discard, reader.value)
+		if err == nil {
+			err = io.EOF
+		}
+	} else if _, ok := err.(flate.CorruptInputError); ok || err == io.ErrUnexpectedEOF {
+		err = ErrDataCorruption
+	}
+
+	return n, err
+}
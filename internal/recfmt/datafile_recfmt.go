@@ -3,29 +3,72 @@ package recfmt
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash"
 	"hash/crc32"
+	"io"
+	"math"
 )
 
-const DataFileHdrSize = 18
+const (
+	DataFileHdrSize = 36
 
-var errDataCorruption = errors.New("corrution detected: datastore files are corrupted")
+	// RecPut is the type of the records storing the value of their key.
+	RecPut RecType = 1
+	// RecDelete is the type of the tombstones marking their key as deleted, they have no value.
+	RecDelete RecType = 2
+	// RecBatchBegin is the type of the records marking the start of a write batch, they have no key or value.
+	RecBatchBegin RecType = 3
+	// RecBatchCommit is the type of the records marking the end of a committed write batch, they have no key or value.
+	RecBatchCommit RecType = 4
+
+	// FlagCompressed marks the records whose value is compressed with DEFLATE.
+	FlagCompressed byte = 1 << 0
+
+	// MaxKeySize is the size of the largest key that fits in a data file record.
+	MaxKeySize = math.MaxUint16
+	// MaxValueSize is the size of the largest value that fits in a data file record.
+	MaxValueSize = math.MaxUint32
+)
+
+var (
+	// ErrDataCorruption happens when a record fails its checksum.
+	ErrDataCorruption = errors.New("corrution detected: datastore files are corrupted")
+	// ErrIncompleteRec happens when a buffer ends before the end of the data, hint or keydir file record it holds.
+	ErrIncompleteRec = errors.New("incomplete record")
+	// errUnknownRecType happens when reading a record of a type unknown to this version.
+	errUnknownRecType = errors.New("unknown record type")
+)
+
+// RecType is the type of a data file record stored in its header.
+type RecType byte
 
 type DataFileRec struct {
-	Key       string
-	Value     string
+	Key       []byte
+	Value     []byte
 	TStamp    int64
+	Expiry    int64
+	BucketId  uint32
+	Type      RecType
+	Flags     byte
+	KeyId     uint32
 	KeySize   uint16
 	ValueSize uint32
-}
 
-func CompressDataFileRec(key, value string, tStamp int64) []byte {
-	buff := make([]byte, DataFileHdrSize+len(key)+len(value))
+	// aad is the part of the header authenticated along with an encrypted record.
+	aad []byte
+	// sealed is the encrypted key and value of an encrypted record.
+	sealed []byte
+}
 
-	binary.LittleEndian.PutUint64(buff[4:], uint64(tStamp))
-	binary.LittleEndian.PutUint16(buff[12:], uint16(len(key)))
-	binary.LittleEndian.PutUint32(buff[14:], uint32(len(value)))
-	copy(buff[DataFileHdrSize:], []byte(key))
-	copy(buff[DataFileHdrSize+len(key):], []byte(value))
+// CompressDataFileRec compresses the given data into a data file record of the given type.
+// bucketId is the id of the bucket the key belongs to, and flags describe how the value is stored.
+// expiry is the time in unix microseconds after which the record expires, zero means it never expires.
+func CompressDataFileRec(recType RecType, bucketId uint32, flags byte, key, value []byte, tStamp, expiry int64) []byte {
+	buff := make([]byte, DataFileHdrSize+len(key), DataFileHdrSize+len(key)+len(value))
+	putDataFileHdr(buff, recType, bucketId, flags, 0, uint16(len(key)), uint32(len(value)), tStamp, expiry)
+	copy(buff[DataFileHdrSize:], key)
+	buff = append(buff, value...)
 
 	checkSum := crc32.ChecksumIEEE(buff[4:])
 	binary.LittleEndian.PutUint32(buff, checkSum)
@@ -33,27 +76,166 @@ func CompressDataFileRec(key, value string, tStamp int64) []byte {
 	return buff
 }
 
-func ExtractDataFileRec(buff []byte) (*DataFileRec, uint32, error) {
+// CompressDataFileHdr compresses the header and the key of a put record whose value is written separately.
+// The checksum of the returned header is left empty, it is set by SetCheckSum after the value is written.
+func CompressDataFileHdr(bucketId uint32, flags byte, key []byte, valueSize uint32, tStamp, expiry int64) []byte {
+	buff := make([]byte, DataFileHdrSize+len(key))
+	putDataFileHdr(buff, RecPut, bucketId, flags, 0, uint16(len(key)), valueSize, tStamp, expiry)
+	copy(buff[DataFileHdrSize:], key)
+
+	return buff
+}
+
+// putDataFileHdr puts the fields of a data file record header except for the checksum into buff.
+func putDataFileHdr(buff []byte, recType RecType, bucketId uint32, flags byte, keyId uint32, keySize uint16,
+	valueSize uint32, tStamp, expiry int64) {
+	binary.LittleEndian.PutUint64(buff[4:], uint64(tStamp))
+	binary.LittleEndian.PutUint64(buff[12:], uint64(expiry))
+	binary.LittleEndian.PutUint32(buff[20:], bucketId)
+	buff[24] = byte(recType)
+	buff[25] = flags
+	binary.LittleEndian.PutUint32(buff[26:], keyId)
+	binary.LittleEndian.PutUint16(buff[30:], keySize)
+	binary.LittleEndian.PutUint32(buff[32:], valueSize)
+}
+
+// NewCheckSum returns the checksum of a data file record, it must be fed the header without its checksum field,
+// then the key and the value of the record.
+func NewCheckSum() hash.Hash32 {
+	return crc32.NewIEEE()
+}
+
+// SetCheckSum sets the checksum field of the given data file record header.
+func SetCheckSum(hdr []byte, checkSum uint32) {
+	binary.LittleEndian.PutUint32(hdr, checkSum)
+}
+
+// NewValueReader returns a reader of the value of a data file record read from r,
+// hdr is the header and the key of the record.
+// The reader validates the record checksum once the whole value is read,
+// and reports a corrupted record instead of the EOF if it does not match.
+// A compressed value is decompressed while read.
+func NewValueReader(hdr []byte, r io.Reader) io.Reader {
+	checkSum := NewCheckSum()
+	checkSum.Write(hdr[4:])
+
+	valueSize := int64(binary.LittleEndian.Uint32(hdr[32:]))
+	reader := &valueReader{
+		r:         io.LimitReader(r, valueSize),
+		checkSum:  checkSum,
+		parsedSum: binary.LittleEndian.Uint32(hdr),
+		remaining: valueSize,
+	}
+	if hdr[25]&FlagCompressed != 0 {
+		return newDecompressReader(reader)
+	}
+
+	return reader
+}
+
+// valueReader streams the value of a data file record and validates its checksum at EOF.
+type valueReader struct {
+	r         io.Reader
+	checkSum  hash.Hash32
+	parsedSum uint32
+	remaining int64
+}
+
+func (reader *valueReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	reader.checkSum.Write(p[:n])
+	reader.remaining -= int64(n)
+
+	if err == io.EOF && (reader.remaining != 0 || reader.checkSum.Sum32() != reader.parsedSum) {
+		return n, ErrDataCorruption
+	}
+
+	return n, err
+}
+
+// IsEncryptedHdr reports whether the record of the given header is encrypted.
+func IsEncryptedHdr(hdr []byte) bool {
+	return binary.LittleEndian.Uint32(hdr[26:]) != 0
+}
+
+// IsCompressed reports whether the value of the record is compressed.
+func (rec *DataFileRec) IsCompressed() bool {
+	return rec.Flags&FlagCompressed != 0
+}
+
+// IsTompStone reports whether the record marks its key as deleted.
+func (rec *DataFileRec) IsTompStone() bool {
+	return rec.Type == RecDelete
+}
+
+// IsBatchBegin reports whether the record marks the start of a write batch.
+func (rec *DataFileRec) IsBatchBegin() bool {
+	return rec.Type == RecBatchBegin
+}
+
+// IsBatchCommit reports whether the record marks the end of a committed write batch.
+func (rec *DataFileRec) IsBatchCommit() bool {
+	return rec.Type == RecBatchCommit
+}
+
+// DataFileRecLen returns the length of the data file record of the given header.
+func DataFileRecLen(hdr []byte) int64 {
+	keySize := binary.LittleEndian.Uint16(hdr[30:])
+	valueSize := binary.LittleEndian.Uint32(hdr[32:])
+
+	return DataFileHdrSize + int64(keySize) + int64(valueSize)
+}
+
+// ExtractDataFileRec extracts a data file record from the given buffer.
+// The returned key and value share the underlying memory of buff.
+// The key and the value of an encrypted record are empty until it is decrypted by an Encrypter.
+// Returns the record and its length in the buffer.
+// Returns ErrIncompleteRec if the buffer ends before the end of the record, and ErrDataCorruption if
+// the record fails its checksum.
+func ExtractDataFileRec(buff []byte) (*DataFileRec, int, error) {
+	if len(buff) < DataFileHdrSize || DataFileRecLen(buff) > int64(len(buff)) {
+		return nil, 0, ErrIncompleteRec
+	}
+
 	parsedSum := binary.LittleEndian.Uint32(buff)
 	tStamp := binary.LittleEndian.Uint64(buff[4:])
-	keySize := binary.LittleEndian.Uint16(buff[12:])
-	valueSize := binary.LittleEndian.Uint32(buff[14:])
-	key := string(buff[DataFileHdrSize : DataFileHdrSize+keySize])
-	valueOffset := uint32(DataFileHdrSize + keySize)
-	value := string(buff[valueOffset : valueOffset+valueSize])
+	expiry := binary.LittleEndian.Uint64(buff[12:])
+	bucketId := binary.LittleEndian.Uint32(buff[20:])
+	recType := RecType(buff[24])
+	flags := buff[25]
+	keyId := binary.LittleEndian.Uint32(buff[26:])
+	keySize := binary.LittleEndian.Uint16(buff[30:])
+	valueSize := binary.LittleEndian.Uint32(buff[32:])
+	valueOffset := DataFileHdrSize + int(keySize)
+	recLen := valueOffset + int(valueSize)
 
-	err := validateCheckSum(parsedSum, buff[4:DataFileHdrSize+uint32(keySize)+valueSize])
+	err := validateCheckSum(parsedSum, buff[4:recLen])
 	if err != nil {
 		return nil, 0, err
 	}
+	if recType < RecPut || recType > RecBatchCommit {
+		return nil, 0, fmt.Errorf("%d: %s", recType, errUnknownRecType)
+	}
 
-	return &DataFileRec{
-		Key:       key,
-		Value:     value,
+	rec := &DataFileRec{
 		TStamp:    int64(tStamp),
+		Expiry:    int64(expiry),
+		BucketId:  bucketId,
+		Type:      recType,
+		Flags:     flags,
+		KeyId:     keyId,
 		KeySize:   keySize,
 		ValueSize: valueSize,
-	}, DataFileHdrSize + valueSize + uint32(keySize), nil
+	}
+	if keyId != 0 {
+		rec.aad = buff[4:DataFileHdrSize]
+		rec.sealed = buff[DataFileHdrSize:recLen]
+	} else {
+		rec.Key = buff[DataFileHdrSize:valueOffset]
+		rec.Value = buff[valueOffset:recLen]
+	}
+
+	return rec, recLen, nil
 }
 
 // validateCheckSum runs the validate check on the data.
@@ -61,7 +243,7 @@ func ExtractDataFileRec(buff []byte) (*DataFileRec, uint32, error) {
 func validateCheckSum(parsedSum uint32, rec []byte) error {
 	wantedSum := crc32.ChecksumIEEE(rec)
 	if parsedSum != wantedSum {
-		return errDataCorruption
+		return ErrDataCorruption
 	}
 
 	return nil
@@ -0,0 +1,222 @@
+package recfmt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+)
+
+const (
+	// gcmNonceSize is the size of the nonce stored before every sealed data.
+	gcmNonceSize = 12
+	// gcmOverhead is the size of the nonce and the authentication tag added to every sealed data.
+	gcmOverhead = gcmNonceSize + 16
+)
+
+var (
+	// errNoKeyProvider happens when reading an encrypted record without a key provider.
+	errNoKeyProvider = errors.New("record is encrypted but no key provider is given")
+	// errDecryption happens when an encrypted record fails to be decrypted.
+	errDecryption = errors.New("decryption failed: wrong key or corrupted record")
+	// errInvalidKeyId happens when a key provider returns zero as the id of its current key.
+	errInvalidKeyId = errors.New("invalid key id")
+)
+
+type (
+	// KeyProvider provides the keys used to encrypt the records with AES-GCM, every key is identified by a non zero id.
+	KeyProvider interface {
+		// CurrentKey returns the id and the key used to encrypt the new records.
+		CurrentKey() (uint32, []byte)
+		// Key returns the key of the given id, used to decrypt the records encrypted with it.
+		Key(id uint32) ([]byte, error)
+	}
+
+	// Encrypter encrypts and decrypts the records with the keys of a key provider.
+	// The encrypted records carry the id of their key, while the records of id zero are not encrypted.
+	// A nil Encrypter writes plain records and fails to read the encrypted ones.
+	Encrypter struct {
+		provider KeyProvider
+		mu       sync.Mutex
+		aeads    map[uint32]cipher.AEAD
+	}
+)
+
+// NewEncrypter returns an encrypter using the keys of the given provider, it returns nil for a nil provider.
+// Returns an error if the current key of the provider is invalid.
+func NewEncrypter(provider KeyProvider) (*Encrypter, error) {
+	if provider == nil {
+		return nil, nil
+	}
+
+	encrypter := &Encrypter{provider: provider, aeads: make(map[uint32]cipher.AEAD)}
+	_, _, err := encrypter.current()
+	if err != nil {
+		return nil, err
+	}
+
+	return encrypter, nil
+}
+
+// Overhead returns the number of bytes an encrypted record adds to its value.
+func (encrypter *Encrypter) Overhead() int {
+	if encrypter == nil {
+		return 0
+	}
+
+	return gcmOverhead
+}
+
+// CompressDataFileRec compresses the given data into a data file record like CompressDataFileRec,
+// the key and the value of the record are encrypted together with the current key,
+// and its header is authenticated along with them.
+func (encrypter *Encrypter) CompressDataFileRec(recType RecType, bucketId uint32, flags byte, key, value []byte,
+	tStamp, expiry int64) ([]byte, error) {
+	if encrypter == nil {
+		return CompressDataFileRec(recType, bucketId, flags, key, value, tStamp, expiry), nil
+	}
+
+	keyId, aead, err := encrypter.current()
+	if err != nil {
+		return nil, err
+	}
+
+	buff := make([]byte, DataFileHdrSize, DataFileHdrSize+len(key)+len(value)+gcmOverhead)
+	putDataFileHdr(buff, recType, bucketId, flags, keyId, uint16(len(key)), uint32(len(value)+gcmOverhead), tStamp, expiry)
+
+	buff, err = seal(aead, buff, append(key[:len(key):len(key)], value...), buff[4:DataFileHdrSize])
+	if err != nil {
+		return nil, err
+	}
+
+	checkSum := crc32.ChecksumIEEE(buff[4:])
+	binary.LittleEndian.PutUint32(buff, checkSum)
+
+	return buff, nil
+}
+
+// Decrypt decrypts the key and the value of the given record if encrypted.
+func (encrypter *Encrypter) Decrypt(rec *DataFileRec) error {
+	if rec.KeyId == 0 {
+		return nil
+	}
+
+	plain, err := encrypter.open(rec.KeyId, rec.sealed, rec.aad)
+	if err != nil {
+		return err
+	}
+
+	rec.Key = plain[:rec.KeySize]
+	rec.Value = plain[rec.KeySize:]
+	return nil
+}
+
+// SealKey encrypts the given key with the current key to be stored in the hint and keydir files.
+// Returns the id of the used key and the encrypted key, or zero and the key itself for a nil encrypter.
+func (encrypter *Encrypter) SealKey(key []byte) (uint32, []byte, error) {
+	if encrypter == nil {
+		return 0, key, nil
+	}
+
+	keyId, aead, err := encrypter.current()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sealed, err := seal(aead, nil, key, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return keyId, sealed, nil
+}
+
+// OpenKey decrypts a key encrypted by SealKey with the key of the given id.
+func (encrypter *Encrypter) OpenKey(keyId uint32, key []byte) ([]byte, error) {
+	if keyId == 0 {
+		return key, nil
+	}
+
+	return encrypter.open(keyId, key, nil)
+}
+
+// current returns the id and the cipher of the current key of the provider.
+func (encrypter *Encrypter) current() (uint32, cipher.AEAD, error) {
+	keyId, key := encrypter.provider.CurrentKey()
+	if keyId == 0 {
+		return 0, nil, fmt.Errorf("%d: %s", keyId, errInvalidKeyId)
+	}
+
+	aead, err := encrypter.aead(keyId, func() ([]byte, error) { return key, nil })
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return keyId, aead, nil
+}
+
+// open decrypts the given sealed data with the key of the given id.
+func (encrypter *Encrypter) open(keyId uint32, sealed, aad []byte) ([]byte, error) {
+	if encrypter == nil {
+		return nil, errNoKeyProvider
+	}
+
+	aead, err := encrypter.aead(keyId, func() ([]byte, error) { return encrypter.provider.Key(keyId) })
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcmOverhead {
+		return nil, errDecryption
+	}
+
+	nonce, cipherText := sealed[:gcmNonceSize], sealed[gcmNonceSize:]
+	plain, err := aead.Open(nil, nonce, cipherText, aad)
+	if err != nil {
+		return nil, errDecryption
+	}
+
+	return plain, nil
+}
+
+// aead returns the cached cipher of the given key id, creating it from the key returned by getKey if not cached.
+// the key of an id is expected to never change.
+func (encrypter *Encrypter) aead(keyId uint32, getKey func() ([]byte, error)) (cipher.AEAD, error) {
+	encrypter.mu.Lock()
+	defer encrypter.mu.Unlock()
+
+	if aead, ok := encrypter.aeads[keyId]; ok {
+		return aead, nil
+	}
+
+	key, err := getKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("key %d: %s", keyId, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	encrypter.aeads[keyId] = aead
+
+	return aead, nil
+}
+
+// seal appends the random nonce followed by the sealed plain text to dst.
+func seal(aead cipher.AEAD, dst, plain, aad []byte) ([]byte, error) {
+	nonce := make([]byte, gcmNonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plain, aad), nil
+}
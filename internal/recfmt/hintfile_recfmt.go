@@ -4,36 +4,60 @@ import (
 	"encoding/binary"
 )
 
-const hintFileHdrSize = 18
+const hintFileHdrSize = 38
 
 // type HintFileRec struct {
 // 	key       string
 // 	keySize   uint16
 // 	tStamp    int64
-// 	valuePos  uint32
+// 	expiry    int64
+// 	bucketId  uint32
+// 	keyId     uint32
+// 	valuePos  int64
 // 	valueSize uint32
 // }
 
-func CompressHintFileRec(key string, rec KeyDirRec) []byte {
+// CompressHintFileRec compresses the given data into a hint file record.
+// keyId is the id of the key the given key is encrypted with by Encrypter.SealKey, zero means it is not encrypted.
+func CompressHintFileRec(key []byte, keyId uint32, rec KeyDirRec) []byte {
 	buff := make([]byte, hintFileHdrSize+len(key))
 	binary.LittleEndian.PutUint64(buff, uint64(rec.TStamp))
-	binary.LittleEndian.PutUint16(buff[8:], uint16(len(key)))
-	binary.LittleEndian.PutUint32(buff[10:], rec.ValueSize)
-	binary.LittleEndian.PutUint32(buff[14:], rec.ValuePos)
-	copy(buff[18:], []byte(key))
+	binary.LittleEndian.PutUint64(buff[8:], uint64(rec.Expiry))
+	binary.LittleEndian.PutUint32(buff[16:], rec.BucketId)
+	binary.LittleEndian.PutUint32(buff[20:], keyId)
+	binary.LittleEndian.PutUint16(buff[24:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(buff[26:], rec.ValueSize)
+	binary.LittleEndian.PutUint64(buff[30:], uint64(rec.ValuePos))
+	copy(buff[hintFileHdrSize:], key)
 	return buff
 }
 
-func ExtractHintFileRec(buff []byte) (string, KeyDirRec, int) {
+// ExtractHintFileRec extracts the hint file record into a keydir record.
+// Returns the key as stored along with the id of the key it is encrypted with,
+// the keydir record and its length in the file.
+// Returns ErrIncompleteRec if the buffer ends before the end of the record.
+func ExtractHintFileRec(buff []byte) ([]byte, uint32, KeyDirRec, int, error) {
+	if len(buff) < hintFileHdrSize {
+		return nil, 0, KeyDirRec{}, 0, ErrIncompleteRec
+	}
+
 	tStamp := binary.LittleEndian.Uint64(buff)
-	keySize := binary.LittleEndian.Uint16(buff[8:])
-	valueSize := binary.LittleEndian.Uint32(buff[10:])
-	valuePos := binary.LittleEndian.Uint32(buff[14:])
-	key := string(buff[hintFileHdrSize : hintFileHdrSize+keySize])
+	expiry := binary.LittleEndian.Uint64(buff[8:])
+	bucketId := binary.LittleEndian.Uint32(buff[16:])
+	keyId := binary.LittleEndian.Uint32(buff[20:])
+	keySize := binary.LittleEndian.Uint16(buff[24:])
+	valueSize := binary.LittleEndian.Uint32(buff[26:])
+	valuePos := binary.LittleEndian.Uint64(buff[30:])
+	if len(buff) < hintFileHdrSize+int(keySize) {
+		return nil, 0, KeyDirRec{}, 0, ErrIncompleteRec
+	}
+	key := buff[hintFileHdrSize : hintFileHdrSize+int(keySize)]
 
-	return key, KeyDirRec{
-		ValuePos:  valuePos,
+	return key, keyId, KeyDirRec{
+		ValuePos:  int64(valuePos),
 		ValueSize: valueSize,
 		TStamp:    int64(tStamp),
-	}, hintFileHdrSize + int(keySize)
+		Expiry:    int64(expiry),
+		BucketId:  bucketId,
+	}, hintFileHdrSize + int(keySize), nil
 }
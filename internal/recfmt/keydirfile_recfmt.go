@@ -3,46 +3,73 @@ package recfmt
 import (
 	"encoding/binary"
 	"strconv"
+	"strings"
 )
 
-const keydirFileHdrSize = 26
+const keydirFileHdrSize = 46
 
 type KeyDirRec struct {
 	FileId    string
-	ValuePos  uint32
+	ValuePos  int64
 	ValueSize uint32
 	TStamp    int64
+	Expiry    int64
+	BucketId  uint32
+}
+
+// IsExpired reports whether the record has expired by the given time in unix microseconds.
+func (rec KeyDirRec) IsExpired(now int64) bool {
+	return rec.Expiry != 0 && rec.Expiry <= now
 }
 
 // CompressKeyDirRec compresses the given data into a keydir file record.
-func CompressKeyDirRec(key string, rec KeyDirRec) []byte {
+// keyId is the id of the key the given key is encrypted with by Encrypter.SealKey, zero means it is not encrypted.
+// The data file of the record is stored by its id, the number its name is made of.
+func CompressKeyDirRec(key []byte, keyId uint32, rec KeyDirRec) []byte {
 	keySize := len(key)
 	buff := make([]byte, keydirFileHdrSize+keySize)
-	fid, _ := strconv.ParseUint(rec.FileId, 10, 64)
+	fid, _ := strconv.ParseUint(strings.TrimSuffix(rec.FileId, ".data"), 10, 64)
 	binary.LittleEndian.PutUint64(buff, fid)
-	binary.LittleEndian.PutUint16(buff[8:], uint16(keySize))
-	binary.LittleEndian.PutUint32(buff[10:], rec.ValueSize)
-	binary.LittleEndian.PutUint32(buff[14:], rec.ValuePos)
-	binary.LittleEndian.PutUint64(buff[18:], uint64(rec.TStamp))
-	copy(buff[26:], []byte(key))
+	binary.LittleEndian.PutUint32(buff[8:], rec.BucketId)
+	binary.LittleEndian.PutUint16(buff[12:], uint16(keySize))
+	binary.LittleEndian.PutUint32(buff[14:], rec.ValueSize)
+	binary.LittleEndian.PutUint64(buff[18:], uint64(rec.ValuePos))
+	binary.LittleEndian.PutUint64(buff[26:], uint64(rec.TStamp))
+	binary.LittleEndian.PutUint64(buff[34:], uint64(rec.Expiry))
+	binary.LittleEndian.PutUint32(buff[42:], keyId)
+	copy(buff[keydirFileHdrSize:], key)
 
 	return buff
 }
 
 // ExtractKeyDirRec extracts the keydir file record into a keydir record.
-// Return the keydir record and its length in the file.
-func ExtractKeyDirRec(buff []byte) (string, KeyDirRec, int) {
-	fileId := strconv.FormatUint(binary.LittleEndian.Uint64(buff), 10)
-	keySize := binary.LittleEndian.Uint16(buff[8:])
-	valueSize := binary.LittleEndian.Uint32(buff[10:])
-	valuePos := binary.LittleEndian.Uint32(buff[14:])
-	tStamp := binary.LittleEndian.Uint64(buff[18:])
-	key := string(buff[26 : keySize+26])
-
-	return key, KeyDirRec{
+// Return the key as stored along with the id of the key it is encrypted with,
+// the keydir record and its length in the file.
+// Returns ErrIncompleteRec if the buffer ends before the end of the record.
+func ExtractKeyDirRec(buff []byte) ([]byte, uint32, KeyDirRec, int, error) {
+	if len(buff) < keydirFileHdrSize {
+		return nil, 0, KeyDirRec{}, 0, ErrIncompleteRec
+	}
+
+	fileId := strconv.FormatUint(binary.LittleEndian.Uint64(buff), 10) + ".data"
+	bucketId := binary.LittleEndian.Uint32(buff[8:])
+	keySize := binary.LittleEndian.Uint16(buff[12:])
+	valueSize := binary.LittleEndian.Uint32(buff[14:])
+	valuePos := binary.LittleEndian.Uint64(buff[18:])
+	tStamp := binary.LittleEndian.Uint64(buff[26:])
+	expiry := binary.LittleEndian.Uint64(buff[34:])
+	keyId := binary.LittleEndian.Uint32(buff[42:])
+	if len(buff) < keydirFileHdrSize+int(keySize) {
+		return nil, 0, KeyDirRec{}, 0, ErrIncompleteRec
+	}
+	key := buff[keydirFileHdrSize : keydirFileHdrSize+int(keySize)]
+
+	return key, keyId, KeyDirRec{
 		FileId:    fileId,
-		ValuePos:  valuePos,
+		ValuePos:  int64(valuePos),
 		ValueSize: valueSize,
 		TStamp:    int64(tStamp),
-	}, keydirFileHdrSize + int(keySize)
+		Expiry:    int64(expiry),
+		BucketId:  bucketId,
+	}, keydirFileHdrSize + int(keySize), nil
 }
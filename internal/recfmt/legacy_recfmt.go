@@ -0,0 +1,56 @@
+package recfmt
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// LegacyDataFileHdrSize is the size of the data file record header of the datastores created before
+	// the record types were added, holding the checksum, the timestamp, the key size and the value size.
+	// The legacy records have no buckets, expiry, flags nor encryption.
+	LegacyDataFileHdrSize = 18
+
+	// legacyTompStone is the value that marked the deleted keys in the legacy records.
+	legacyTompStone = "8890fc70294d02dbde257989e802451c2276be7fb177c3ca4399dc4728e4e1e0"
+)
+
+// LegacyDataFileRecLen returns the length of the legacy data file record of the given header.
+func LegacyDataFileRecLen(hdr []byte) int64 {
+	keySize := binary.LittleEndian.Uint16(hdr[12:])
+	valueSize := binary.LittleEndian.Uint32(hdr[14:])
+
+	return LegacyDataFileHdrSize + int64(keySize) + int64(valueSize)
+}
+
+// ExtractLegacyDataFileRec extracts a data file record written before the record types were added.
+// The record is of the default bucket, and the tombstones get their record type instead of their special value.
+// Returns the record and its length in the buffer, or ErrIncompleteRec if the buffer ends before the record.
+func ExtractLegacyDataFileRec(buff []byte) (*DataFileRec, int, error) {
+	if len(buff) < LegacyDataFileHdrSize || LegacyDataFileRecLen(buff) > int64(len(buff)) {
+		return nil, 0, ErrIncompleteRec
+	}
+
+	parsedSum := binary.LittleEndian.Uint32(buff)
+	keySize := binary.LittleEndian.Uint16(buff[12:])
+	valueOffset := LegacyDataFileHdrSize + int(keySize)
+	recLen := int(LegacyDataFileRecLen(buff))
+
+	err := validateCheckSum(parsedSum, buff[4:recLen])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rec := &DataFileRec{
+		Type:    RecPut,
+		TStamp:  int64(binary.LittleEndian.Uint64(buff[4:])),
+		KeySize: keySize,
+		Key:     buff[LegacyDataFileHdrSize:valueOffset],
+		Value:   buff[valueOffset:recLen],
+	}
+	if string(rec.Value) == legacyTompStone {
+		rec.Type, rec.Value = RecDelete, nil
+	}
+	rec.ValueSize = uint32(len(rec.Value))
+
+	return rec, recLen, nil
+}
@@ -0,0 +1,240 @@
+package bitcask
+
+import (
+	"fmt"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+type (
+	// IterOption configures the iteration done by an Iterator.
+	IterOption func(*iterOptions)
+
+	// iterOptions groups the options passed to Iterator.
+	iterOptions struct {
+		keysOnly bool
+		ordered  bool
+		reverse  bool
+		start    string
+		end      string
+	}
+
+	// Iterator is a cursor over the key/value pairs of a snapshot of the datastore.
+	// The iterator starts before the first pair, so Next must be called before reading Key and Value.
+	//
+	//	it := bitcask.Iterator()
+	//	defer it.Close()
+	//	for it.Next() {
+	//		use(it.Key(), it.Value())
+	//	}
+	//	if err := it.Err(); err != nil {
+	//		...
+	//	}
+	Iterator struct {
+		snapshot    *Snapshot
+		ownSnapshot bool
+		entries     []iterEntry
+		pos         int
+		opts        iterOptions
+		key         []byte
+		value       []byte
+		err         error
+	}
+
+	// iterEntry holds a key visited by an Iterator and its record.
+	iterEntry struct {
+		key string
+		rec recfmt.KeyDirRec
+	}
+)
+
+// IterKeysOnly makes the iterator skip reading the values, so it never touches the data files.
+func IterKeysOnly() IterOption {
+	return func(opts *iterOptions) {
+		opts.keysOnly = true
+	}
+}
+
+// IterPrefix limits the iteration to the keys starting with the given prefix in ascending order.
+func IterPrefix(prefix []byte) IterOption {
+	return func(opts *iterOptions) {
+		opts.ordered = true
+		opts.start, opts.end = string(prefix), prefixEnd(prefix)
+	}
+}
+
+// IterRange limits the iteration to the keys in [start, end) in ascending order.
+// An empty end means no upper bound.
+func IterRange(start, end []byte) IterOption {
+	return func(opts *iterOptions) {
+		opts.ordered = true
+		opts.start, opts.end = string(start), string(end)
+	}
+}
+
+// IterReverse makes the iteration go in descending order of the keys.
+func IterReverse() IterOption {
+	return func(opts *iterOptions) {
+		opts.ordered = true
+		opts.reverse = true
+	}
+}
+
+// Iterator returns an iterator over a snapshot of the datastore taken at the time of the call.
+// The iterator visits the keys in no specific order unless any of the ordering options is given.
+// The iterator holds the keys it visits and their records, without copying the keydir,
+// so IterPrefix and IterRange also bound its memory on large datastores.
+// The iterator must be closed when no longer needed.
+func (bitcask *Bitcask) Iterator(opts ...IterOption) *Iterator {
+	iterOpts := parseIterOpts(opts)
+
+	bitcask.accessMu.RLock()
+	defer bitcask.accessMu.RUnlock()
+
+	// the snapshot owned by the iterator only references the files, the records are kept by the iterator.
+	snapshot := &Snapshot{bitcask: bitcask, files: make(map[string]bool)}
+	it := &Iterator{
+		snapshot:    snapshot,
+		ownSnapshot: true,
+		entries:     make([]iterEntry, 0),
+		pos:         -1,
+		opts:        iterOpts,
+	}
+	bitcask.liveRecs(bitcask.keyDir, iterOpts, func(key string, rec recfmt.KeyDirRec) {
+		it.entries = append(it.entries, iterEntry{key: key, rec: rec})
+		snapshot.files[rec.FileId] = true
+	})
+
+	for fileId := range snapshot.files {
+		bitcask.retainFile(fileId)
+	}
+
+	return it
+}
+
+// Scan returns an iterator over the keys starting with the given prefix in ascending order.
+func (bitcask *Bitcask) Scan(prefix []byte, opts ...IterOption) *Iterator {
+	return bitcask.Iterator(append(opts, IterPrefix(prefix))...)
+}
+
+// Range returns an iterator over the keys in [start, end) in ascending order.
+// An empty end means no upper bound.
+func (bitcask *Bitcask) Range(start, end []byte, opts ...IterOption) *Iterator {
+	return bitcask.Iterator(append(opts, IterRange(start, end))...)
+}
+
+// Iterator returns an iterator over the snapshot, closing it does not close the snapshot.
+func (snapshot *Snapshot) Iterator(opts ...IterOption) *Iterator {
+	snapshot.mu.RLock()
+	defer snapshot.mu.RUnlock()
+
+	iterOpts := parseIterOpts(opts)
+	it := &Iterator{
+		snapshot: snapshot,
+		entries:  make([]iterEntry, 0),
+		pos:      -1,
+		opts:     iterOpts,
+	}
+	if !snapshot.closed {
+		snapshot.bitcask.liveRecs(snapshot.keyDir, iterOpts, func(key string, rec recfmt.KeyDirRec) {
+			it.entries = append(it.entries, iterEntry{key: key, rec: rec})
+		})
+	}
+
+	return it
+}
+
+func parseIterOpts(opts []IterOption) iterOptions {
+	iterOpts := iterOptions{}
+	for _, opt := range opts {
+		opt(&iterOpts)
+	}
+
+	return iterOpts
+}
+
+// prefixEnd returns the smallest key greater than all the keys starting with the given prefix,
+// or an empty key if there is no such key.
+func prefixEnd(prefix []byte) string {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+
+	return ""
+}
+
+// Next advances the iterator to the next key/value pair.
+// Returns false when there are no more pairs or an error happens, the error is reported by Err.
+// An iterator is not safe for concurrent use.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.snapshot.mu.RLock()
+	defer it.snapshot.mu.RUnlock()
+
+	if it.snapshot.closed {
+		it.err = fmt.Errorf("Next: %s", errSnapshotClosed)
+		return false
+	}
+
+	it.pos++
+	if it.pos >= len(it.entries) {
+		it.key, it.value = nil, nil
+		return false
+	}
+
+	entry := it.entries[it.pos]
+	it.key = []byte(entry.key)
+	if it.opts.keysOnly {
+		return true
+	}
+
+	rec := entry.rec
+	it.value, it.err = it.snapshot.bitcask.dataStore.ReadValueFromFile(rec.FileId, it.key, rec.ValuePos, rec.ValueSize)
+	if it.err != nil {
+		it.key, it.value = nil, nil
+		return false
+	}
+
+	return true
+}
+
+// Key returns the key of the current pair.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value of the current pair, it is always nil for key only iterators.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error that stopped the iteration if any, such as a corrupted record or a failed read.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// fold folds fn over the pairs of the given iterator.
+func fold(it *Iterator, fn func([]byte, []byte, any) any, acc any) any {
+	for it.Next() {
+		acc = fn(it.Key(), it.Value(), acc)
+	}
+
+	return acc
+}
+
+// Close releases the iterator and the snapshot it owns.
+func (it *Iterator) Close() error {
+	if it.ownSnapshot {
+		it.snapshot.Close()
+	}
+	it.entries = nil
+
+	return it.err
+}
@@ -0,0 +1,180 @@
+package bitcask
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultMergeInterval is the interval the merge policy is checked at if not given.
+const defaultMergeInterval = time.Minute
+
+type (
+	// MergePolicy configures the merges run in the background by WithMergePolicy.
+	// A merge runs when the current time is within the window and any of the given thresholds is reached,
+	// a policy without thresholds merges whenever the files Merge would rewrite have any dead bytes.
+	// The thresholds only consider the files other than the active file, as Merge does not rewrite it.
+	MergePolicy struct {
+		// Interval is how often the policy is checked, defaults to a minute.
+		Interval time.Duration
+		// MinFragmentation merges when the ratio of the dead bytes to the size of the files reaches it.
+		MinFragmentation float64
+		// MinDeadBytes merges when the size of the dead records reaches it.
+		MinDeadBytes int64
+		// MinFiles merges when the number of files reaches it.
+		MinFiles int
+		// WindowStart and WindowEnd limit the merges to the given time of the day in the local time zone,
+		// given as the duration since midnight. A window ending before it starts spans midnight,
+		// and an empty window allows merging at any time.
+		WindowStart time.Duration
+		WindowEnd   time.Duration
+		// OnMerge is called with the result of every merge run by the policy, the next merge waits for it to return.
+		// It may call Close, which stops the background merges without waiting for it to return.
+		OnMerge func(MergeResult)
+	}
+
+	// MergeResult describes a merge run by a MergePolicy.
+	MergeResult struct {
+		// Start is the time the merge started at.
+		Start time.Time
+		// Duration is how long the merge took.
+		Duration time.Duration
+		// ReclaimedBytes is the size of the files before the merge minus their size after it.
+		ReclaimedBytes int64
+		// Err is the error the merge failed with if any.
+		Err error
+	}
+
+	// merger runs the merges of a merge policy in the background.
+	merger struct {
+		policy MergePolicy
+		stop   chan struct{}
+		done   chan struct{}
+	}
+)
+
+// WithMergePolicy runs Merge in the background whenever the given policy is met, it requires write permission.
+// The background merges stop when the datastore is closed.
+func WithMergePolicy(policy MergePolicy) Option {
+	return optionFunc(func(usrOpts *options) error {
+		if policy.Interval < 0 {
+			return fmt.Errorf("merge interval %s: %s", policy.Interval, errInvalidOpt)
+		}
+		if policy.MinFragmentation < 0 || policy.MinFragmentation > 1 {
+			return fmt.Errorf("merge fragmentation %v: %s", policy.MinFragmentation, errInvalidOpt)
+		}
+		if policy.MinDeadBytes < 0 || policy.MinFiles < 0 {
+			return fmt.Errorf("merge thresholds: %s", errInvalidOpt)
+		}
+		if !isTimeOfDay(policy.WindowStart) || !isTimeOfDay(policy.WindowEnd) {
+			return fmt.Errorf("merge window %s-%s: %s", policy.WindowStart, policy.WindowEnd, errInvalidOpt)
+		}
+		if policy.Interval == 0 {
+			policy.Interval = defaultMergeInterval
+		}
+		usrOpts.mergePolicy = &policy
+		return nil
+	})
+}
+
+// startMerger starts running the merges of the given policy in the background.
+func (bitcask *Bitcask) startMerger(policy MergePolicy) {
+	bitcask.merger = &merger{
+		policy: policy,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go bitcask.runMerger(bitcask.merger)
+}
+
+// stopMerger stops the background merges and waits for the running merge if any.
+func (bitcask *Bitcask) stopMerger() {
+	if bitcask.merger == nil {
+		return
+	}
+
+	close(bitcask.merger.stop)
+	<-bitcask.merger.done
+	bitcask.merger = nil
+}
+
+// runMerger checks the policy of the given merger on every interval and merges if it is met, until stopped.
+func (bitcask *Bitcask) runMerger(merger *merger) {
+	defer close(merger.done)
+
+	ticker := time.NewTicker(merger.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-merger.stop:
+			return
+		case now := <-ticker.C:
+			if !merger.policy.inWindow(now) || !merger.policy.isMet(bitcask.Stats()) {
+				continue
+			}
+
+			res := MergeResult{Start: now}
+			before := bitcask.Stats().TotalBytes()
+			res.Err = bitcask.Merge()
+			res.Duration = time.Since(now)
+			res.ReclaimedBytes = before - bitcask.Stats().TotalBytes()
+
+			if merger.policy.OnMerge != nil && !merger.notify(res) {
+				return
+			}
+		}
+	}
+}
+
+// notify calls OnMerge with the given result and waits for it to return.
+// It returns false if the merger is stopped before OnMerge returns, so that Close
+// called by OnMerge does not wait for it.
+func (merger *merger) notify(res MergeResult) bool {
+	called := make(chan struct{})
+	go func() {
+		defer close(called)
+		merger.policy.OnMerge(res)
+	}()
+
+	select {
+	case <-called:
+		return true
+	case <-merger.stop:
+		return false
+	}
+}
+
+// isMet reports whether the files other than the active file reach any of the thresholds of the policy.
+func (policy MergePolicy) isMet(stats Stats) bool {
+	delete(stats.Files, stats.ActiveFile)
+	dead := stats.DeadBytes()
+
+	if policy.MinFragmentation == 0 && policy.MinDeadBytes == 0 && policy.MinFiles == 0 {
+		return dead > 0
+	}
+
+	return (policy.MinFragmentation > 0 && dead > 0 && stats.Fragmentation() >= policy.MinFragmentation) ||
+		(policy.MinDeadBytes > 0 && dead >= policy.MinDeadBytes) ||
+		(policy.MinFiles > 0 && len(stats.Files) >= policy.MinFiles)
+}
+
+// inWindow reports whether the given time is within the window of the policy.
+func (policy MergePolicy) inWindow(now time.Time) bool {
+	start, end := policy.WindowStart, policy.WindowEnd
+	if start == end {
+		return true
+	}
+
+	hour, minute, second := now.Clock()
+	timeOfDay := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+	if start < end {
+		return start <= timeOfDay && timeOfDay < end
+	}
+
+	return timeOfDay >= start || timeOfDay < end
+}
+
+func isTimeOfDay(d time.Duration) bool {
+	return d >= 0 && d < 24*time.Hour
+}
@@ -0,0 +1,143 @@
+package bitcask
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mergeInterval is the interval the merge policies of the tests are checked at.
+const mergeInterval = 10 * time.Millisecond
+
+// timeOfDay returns the duration since midnight of the given time.
+func timeOfDay(now time.Time) time.Duration {
+	hour, minute, second := now.Clock()
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+}
+
+func TestMergePolicyThreshold(t *testing.T) {
+	dir := t.TempDir()
+	want := fillStore(t, dir)
+
+	results := make(chan MergeResult, 1)
+	bc := openStore(t, dir, WithMaxFileSize(2048), WithMergePolicy(MergePolicy{
+		Interval:     mergeInterval,
+		MinDeadBytes: 1,
+		OnMerge: func(res MergeResult) {
+			select {
+			case results <- res:
+			default:
+			}
+		},
+	}))
+	defer bc.Close()
+
+	select {
+	case res := <-results:
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if res.ReclaimedBytes <= 0 {
+			t.Fatalf("got %d reclaimed bytes, want some", res.ReclaimedBytes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the policy did not merge the dead bytes")
+	}
+	checkContents(t, bc, want)
+}
+
+func TestMergePolicyWindow(t *testing.T) {
+	dir := t.TempDir()
+	want := fillStore(t, dir)
+	files := listFiles(t, dir, ".data")
+
+	// the window starts two hours from now and lasts an hour.
+	start := (timeOfDay(time.Now()) + 2*time.Hour) % (24 * time.Hour)
+	merged := make(chan struct{}, 1)
+	bc := openStore(t, dir, WithMaxFileSize(2048), WithMergePolicy(MergePolicy{
+		Interval:     mergeInterval,
+		MinDeadBytes: 1,
+		WindowStart:  start,
+		WindowEnd:    (start + time.Hour) % (24 * time.Hour),
+		OnMerge: func(MergeResult) {
+			merged <- struct{}{}
+		},
+	}))
+	defer bc.Close()
+
+	select {
+	case <-merged:
+		t.Fatal("the policy merged out of its window")
+	case <-time.After(20 * mergeInterval):
+	}
+	if got := listFiles(t, dir, ".data"); !reflect.DeepEqual(got, files) {
+		t.Fatalf("got data files %v, want %v", got, files)
+	}
+	checkContents(t, bc, want)
+}
+
+func TestMergePolicyInWindow(t *testing.T) {
+	at := func(d time.Duration) time.Time {
+		return time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local).Add(d)
+	}
+
+	for _, test := range []struct {
+		start, end, now time.Duration
+		want            bool
+	}{
+		{0, 0, 13 * time.Hour, true},
+		{2 * time.Hour, 4 * time.Hour, 3 * time.Hour, true},
+		{2 * time.Hour, 4 * time.Hour, 4 * time.Hour, false},
+		{2 * time.Hour, 4 * time.Hour, time.Hour, false},
+		{22 * time.Hour, 2 * time.Hour, 23 * time.Hour, true},
+		{22 * time.Hour, 2 * time.Hour, time.Hour, true},
+		{22 * time.Hour, 2 * time.Hour, 12 * time.Hour, false},
+	} {
+		policy := MergePolicy{WindowStart: test.start, WindowEnd: test.end}
+		if got := policy.inWindow(at(test.now)); got != test.want {
+			t.Fatalf("window %s-%s at %s: got %v, want %v", test.start, test.end, test.now, got, test.want)
+		}
+	}
+}
+
+func TestMergePolicyIdleClose(t *testing.T) {
+	bc := openStore(t, t.TempDir(), WithMergePolicy(MergePolicy{Interval: time.Hour}))
+
+	closed := make(chan struct{})
+	go func() {
+		bc.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for the next check of the policy")
+	}
+}
+
+func TestMergePolicyCloseOnMerge(t *testing.T) {
+	dir := t.TempDir()
+	want := fillStore(t, dir)
+
+	opened := make(chan *Bitcask, 1)
+	closed := make(chan struct{})
+	bc := openStore(t, dir, WithMaxFileSize(2048), WithMergePolicy(MergePolicy{
+		Interval:     mergeInterval,
+		MinDeadBytes: 1,
+		OnMerge: func(MergeResult) {
+			(<-opened).Close()
+			close(closed)
+		},
+	}))
+	opened <- bc
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close called by OnMerge did not return")
+	}
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkContents(t, bc, want)
+}
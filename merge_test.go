@@ -0,0 +1,380 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Eslam-Nawara/bitcask/internal/datastore"
+)
+
+// mergeCrash is a datastore whose merge is interrupted, built from the files of a completed merge.
+type mergeCrash struct {
+	dir string
+	// want is the contents of the datastore.
+	want map[string]string
+	// merged are the data files written by the merge, and old are the data and hint files it replaces.
+	merged, old []string
+	// mergedDir holds the files of the completed merge.
+	mergedDir string
+}
+
+// newMergeCrash builds a closed datastore and runs its merge on a copy, so that the merge files
+// can be laid out in the datastore as if the merge was interrupted at any point.
+func newMergeCrash(t *testing.T) *mergeCrash {
+	t.Helper()
+
+	crash := &mergeCrash{dir: t.TempDir()}
+	crash.want = fillStore(t, crash.dir)
+
+	crash.mergedDir = copyDir(t, crash.dir)
+	bc := openStore(t, crash.mergedDir, WithMaxFileSize(2048))
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	before := make(map[string]bool)
+	for _, name := range listFiles(t, crash.dir, "") {
+		before[name] = true
+	}
+	after := make(map[string]bool)
+	for _, name := range listFiles(t, crash.mergedDir, "") {
+		after[name] = true
+	}
+	for _, name := range listFiles(t, crash.mergedDir, ".data") {
+		if !before[name] {
+			crash.merged = append(crash.merged, name)
+		}
+	}
+	for _, name := range listFiles(t, crash.dir, "") {
+		if (strings.HasSuffix(name, ".data") || strings.HasSuffix(name, ".hint")) && !after[name] {
+			crash.old = append(crash.old, name)
+		}
+	}
+	if len(crash.merged) < 2 || len(crash.old) < 2 {
+		t.Fatalf("the merge wrote %d files replacing %d, want several", len(crash.merged), len(crash.old))
+	}
+
+	return crash
+}
+
+// writeMerged copies the given merge file and its hint file to the datastore, with the given extension.
+func (crash *mergeCrash) writeMerged(t *testing.T, file, ext string) {
+	t.Helper()
+
+	for _, name := range []string{file, strings.TrimSuffix(file, ".data") + ".hint"} {
+		copyFile(t, path.Join(crash.mergedDir, name), path.Join(crash.dir, name+ext))
+	}
+}
+
+// writeManifest writes the merge manifest recording the merge as committed.
+func (crash *mergeCrash) writeManifest(t *testing.T) {
+	t.Helper()
+
+	var manifest bytes.Buffer
+	for _, file := range crash.merged {
+		fmt.Fprintf(&manifest, "merged %s\n", file)
+	}
+	for _, file := range crash.old {
+		fmt.Fprintf(&manifest, "old %s\n", file)
+	}
+	if err := os.WriteFile(path.Join(crash.dir, ".merge"), manifest.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkFiles checks that no merge leftovers remain, and whether the old or the merged files are in the datastore.
+func (crash *mergeCrash) checkFiles(t *testing.T, committed bool) {
+	t.Helper()
+
+	if tmpFiles := listFiles(t, crash.dir, ".tmp"); len(tmpFiles) != 0 {
+		t.Fatalf("merge files are left: %v", tmpFiles)
+	}
+	if fileExists(path.Join(crash.dir, ".merge")) {
+		t.Fatal("the merge manifest is left")
+	}
+	for _, file := range crash.old {
+		if exists := fileExists(path.Join(crash.dir, file)); exists == committed {
+			t.Fatalf("old file %s: got exists %v, want %v", file, exists, !committed)
+		}
+	}
+	for _, file := range crash.merged {
+		if exists := fileExists(path.Join(crash.dir, file)); exists != committed {
+			t.Fatalf("merged file %s: got exists %v, want %v", file, exists, committed)
+		}
+	}
+}
+
+func TestMergeCrashBeforeManifest(t *testing.T) {
+	crash := newMergeCrash(t)
+	for _, file := range crash.merged {
+		crash.writeMerged(t, file, ".tmp")
+	}
+
+	bc := openStore(t, crash.dir)
+	defer bc.Close()
+	crash.checkFiles(t, false)
+	checkContents(t, bc, crash.want)
+}
+
+func TestMergeCrashBeforeManifestPartialFiles(t *testing.T) {
+	crash := newMergeCrash(t)
+	crash.writeMerged(t, crash.merged[0], ".tmp")
+	// the last merge file is cut short as if the merge died while writing it.
+	last := path.Join(crash.dir, crash.merged[0]+".tmp")
+	if err := os.Truncate(last, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	bc := openStore(t, crash.dir)
+	defer bc.Close()
+	crash.checkFiles(t, false)
+	checkContents(t, bc, crash.want)
+}
+
+func TestMergeCrashPartialPublish(t *testing.T) {
+	crash := newMergeCrash(t)
+	crash.writeManifest(t)
+	crash.writeMerged(t, crash.merged[0], "")
+	for _, file := range crash.merged[1:] {
+		crash.writeMerged(t, file, ".tmp")
+	}
+
+	bc := openStore(t, crash.dir)
+	defer bc.Close()
+	crash.checkFiles(t, true)
+	checkContents(t, bc, crash.want)
+}
+
+func TestMergeCrashPendingDeletes(t *testing.T) {
+	crash := newMergeCrash(t)
+	crash.writeManifest(t)
+	for _, file := range crash.merged {
+		crash.writeMerged(t, file, "")
+	}
+	if err := os.Remove(path.Join(crash.dir, crash.old[0])); err != nil {
+		t.Fatal(err)
+	}
+
+	bc := openStore(t, crash.dir)
+	defer bc.Close()
+	crash.checkFiles(t, true)
+	checkContents(t, bc, crash.want)
+}
+
+func TestMergeReopen(t *testing.T) {
+	dir := t.TempDir()
+	want := fillStore(t, dir)
+
+	bc := openStore(t, dir, WithMaxFileSize(2048))
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, bc, want)
+	bc.Close()
+
+	if tmpFiles := listFiles(t, dir, ".tmp"); len(tmpFiles) != 0 {
+		t.Fatalf("merge files are left: %v", tmpFiles)
+	}
+	if fileExists(path.Join(dir, ".merge")) {
+		t.Fatal("the merge manifest is left")
+	}
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkContents(t, bc, want)
+}
+
+func TestMergeRecompress(t *testing.T) {
+	dir := t.TempDir()
+	want := make(map[string]string)
+	bc := openStore(t, dir)
+	for i := 0; i < 20; i++ {
+		key, value := fmt.Sprintf("key%02d", i), strings.Repeat(fmt.Sprintf(`{"id": %d}`, i), 100)
+		if err := bc.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = value
+	}
+	uncompressed := bc.Stats().TotalBytes()
+	bc.Close()
+
+	// merging after the compression is enabled compresses the old records, then disabling it decompresses them.
+	for _, opts := range [][]Option{{WithCompression(64)}, {}} {
+		bc = openStore(t, dir, opts...)
+		if err := bc.Merge(); err != nil {
+			t.Fatal(err)
+		}
+		checkContents(t, bc, want)
+		total := bc.Stats().TotalBytes()
+		bc.Close()
+
+		if compressed := len(opts) > 0; compressed && total >= uncompressed/2 {
+			t.Fatalf("got %d bytes after merging with the compression, want less than half of %d", total, uncompressed)
+		} else if !compressed && total != uncompressed {
+			t.Fatalf("got %d bytes after merging without the compression, want %d", total, uncompressed)
+		}
+
+		bc = openStore(t, dir)
+		checkContents(t, bc, want)
+		bc.Close()
+	}
+}
+
+// mergeWriter rewrites and deletes its own keys until stopped, and keeps their last written values.
+// it counts the writes done while a merge is running.
+func mergeWriter(t *testing.T, bc *Bitcask, keys []string, stop <-chan struct{}, merging *atomic.Bool,
+	duringMerge *atomic.Int64) map[string]string {
+	want := make(map[string]string)
+	for _, key := range keys {
+		want[key] = key + "-initial"
+	}
+	for n := 0; ; n++ {
+		select {
+		case <-stop:
+			return want
+		default:
+		}
+
+		key := keys[n%len(keys)]
+		wasMerging := merging.Load()
+		if n%7 == 0 {
+			if err := bc.Delete(key); err != nil {
+				t.Error(err)
+				return want
+			}
+			delete(want, key)
+		} else {
+			value := fmt.Sprintf("%s-%d", key, n)
+			if err := bc.Put(key, value); err != nil {
+				t.Error(err)
+				return want
+			}
+			want[key] = value
+		}
+		if wasMerging && merging.Load() {
+			duringMerge.Add(1)
+		}
+	}
+}
+
+// mergeReader reads and iterates the keys until stopped, checking that every value read is one written to its key.
+func mergeReader(t *testing.T, bc *Bitcask, keys []string, stop <-chan struct{}) {
+	for n := 0; ; n++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		key := keys[n%len(keys)]
+		value, err := bc.Get(key)
+		if err != nil && !strings.Contains(err.Error(), datastore.ErrKeyNotExist.Error()) {
+			t.Errorf("Get(%q): %s", key, err)
+			return
+		}
+		if err == nil && !strings.HasPrefix(value, key+"-") {
+			t.Errorf("Get(%q): got %q", key, value)
+			return
+		}
+
+		if n%50 != 0 {
+			continue
+		}
+		it := bc.Iterator()
+		for it.Next() {
+			if !bytes.HasPrefix(it.Value(), append(it.Key(), '-')) {
+				t.Errorf("iterated %q: got %q", it.Key(), it.Value())
+			}
+		}
+		if err := it.Close(); err != nil {
+			t.Errorf("iterator: %s", err)
+			return
+		}
+	}
+}
+
+func TestMergeConcurrentAccess(t *testing.T) {
+	const writers, readers = 4, 2
+	dir := t.TempDir()
+	bc := openStore(t, dir, WithMaxFileSize(4096))
+	keys := make([][]string, writers)
+	allKeys := make([]string, 0)
+	for i := 0; i < 400; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		if err := bc.Put(key, key+"-initial"); err != nil {
+			t.Fatal(err)
+		}
+		keys[i%writers] = append(keys[i%writers], key)
+		allKeys = append(allKeys, key)
+	}
+	bc.Close()
+
+	// the initial records are in files other than the active one after reopening, so Merge rewrites them
+	// while the writers rewrite their keys, which must keep their newer records.
+	bc = openStore(t, dir, WithMaxFileSize(4096))
+	var merging atomic.Bool
+	var duringMerge atomic.Int64
+	stop := make(chan struct{})
+	written := make([]map[string]string, writers)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			written[w] = mergeWriter(t, bc, keys[w], stop, &merging, &duringMerge)
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mergeReader(t, bc, allKeys, stop)
+		}()
+	}
+
+	for round := 0; round < 5; round++ {
+		merging.Store(true)
+		err := bc.Merge()
+		merging.Store(false)
+		if err != nil {
+			close(stop)
+			wg.Wait()
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+	if duringMerge.Load() == 0 {
+		t.Fatal("no key was rewritten during a merge")
+	}
+
+	want := make(map[string]string)
+	for _, contents := range written {
+		for key, value := range contents {
+			want[key] = value
+		}
+	}
+	checkContents(t, bc, want)
+	read := make(map[string]string)
+	for _, key := range bc.ListKeys() {
+		value, err := bc.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read[key] = value
+	}
+	bc.Close()
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkContents(t, bc, read)
+}
@@ -0,0 +1,188 @@
+package bitcask
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// baselineTompStone is the value the datastores created before the record types wrote for the deleted keys.
+const baselineTompStone = "8890fc70294d02dbde257989e802451c2276be7fb177c3ca4399dc4728e4e1e0"
+
+// baselineRec returns a data file record in the format of the datastores created before the record types.
+func baselineRec(key, value string, tStamp int64) []byte {
+	buff := make([]byte, 18+len(key)+len(value))
+	binary.LittleEndian.PutUint64(buff[4:], uint64(tStamp))
+	binary.LittleEndian.PutUint16(buff[12:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(buff[14:], uint32(len(value)))
+	copy(buff[18:], key)
+	copy(buff[18+len(key):], value)
+	binary.LittleEndian.PutUint32(buff, crc32.ChecksumIEEE(buff[4:]))
+
+	return buff
+}
+
+// baselineHint returns a hint file record in the format of the datastores created before the record types.
+func baselineHint(key string, valuePos, valueSize uint32, tStamp int64) []byte {
+	buff := make([]byte, 18+len(key))
+	binary.LittleEndian.PutUint64(buff, uint64(tStamp))
+	binary.LittleEndian.PutUint16(buff[8:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(buff[10:], valueSize)
+	binary.LittleEndian.PutUint32(buff[14:], valuePos)
+	copy(buff[18:], key)
+
+	return buff
+}
+
+func writeFile(t *testing.T, name string, chunks ...[]byte) {
+	t.Helper()
+
+	var data []byte
+	for _, chunk := range chunks {
+		data = append(data, chunk...)
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeBaselineStore writes a datastore in the format before the record types, and returns its contents.
+func writeBaselineStore(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	// the first file was merged, so it has a hint file of the baseline format.
+	first := baselineRec("a", "1", 100)
+	writeFile(t, path.Join(dir, "1000.data"), first, baselineRec("b", "2", 101))
+	writeFile(t, path.Join(dir, "1000.hint"),
+		baselineHint("a", 18+1, 1, 100), baselineHint("b", uint32(len(first))+18+1, 1, 101))
+	writeFile(t, path.Join(dir, "2000.data"),
+		baselineRec("a", "3", 200), baselineRec("b", baselineTompStone, 201), baselineRec("c", "4", 202))
+	writeFile(t, path.Join(dir, "keydir"), []byte("stale keydir of the baseline format"))
+
+	return map[string]string{"a": "3", "c": "4"}
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	dir := t.TempDir()
+	want := writeBaselineStore(t, dir)
+
+	bc := openStore(t, dir)
+	checkContents(t, bc, want)
+	if err := bc.Put("d", "5"); err != nil {
+		t.Fatal(err)
+	}
+	want["d"] = "5"
+	bc.Close()
+
+	if fileExists(path.Join(dir, "1000.hint")) {
+		t.Fatal("the hint file of the baseline format is left")
+	}
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkContents(t, bc, want)
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, bc, want)
+}
+
+func TestMigrateBaselineTornTail(t *testing.T) {
+	dir := t.TempDir()
+	torn := baselineRec("c", "torn value", 300)
+	writeFile(t, path.Join(dir, "1000.data"), baselineRec("a", "1", 100), baselineRec("b", "2", 101), torn[:25])
+
+	bc := openStore(t, dir)
+	defer bc.Close()
+	checkContents(t, bc, map[string]string{"a": "1", "b": "2"})
+	if err := bc.Put("c", "3"); err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, bc, map[string]string{"a": "1", "b": "2", "c": "3"})
+}
+
+// baselineStoreContents returns the contents of the datastore in testdata/baseline, written by the baseline code:
+// 50 keys, the first 10 of them overwritten and the next 5 deleted before a merge, then 5 more keys
+// and the deletion of key49 after it.
+func baselineStoreContents() map[string]string {
+	want := make(map[string]string)
+	for i := 0; i < 55; i++ {
+		want[fmt.Sprintf("key%02d", i)] = fmt.Sprintf("value%02d", i)
+	}
+	for i := 0; i < 10; i++ {
+		want[fmt.Sprintf("key%02d", i)] = fmt.Sprintf("updated%02d", i)
+	}
+	for i := 10; i < 15; i++ {
+		delete(want, fmt.Sprintf("key%02d", i))
+	}
+	delete(want, "key49")
+
+	return want
+}
+
+func TestMigrateBaselineStore(t *testing.T) {
+	dir := copyDir(t, path.Join("testdata", "baseline"))
+	want := baselineStoreContents()
+
+	bc := openStore(t, dir)
+	checkContents(t, bc, want)
+	if !bc.RecoveryReport().IsClean() {
+		t.Fatalf("got recovery report %+v, want a clean one", bc.RecoveryReport())
+	}
+	bc.Close()
+
+	manifest, err := os.ReadFile(path.Join(dir, "MANIFEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(manifest), "version 2\n") {
+		t.Fatalf("got manifest %q, want version 2", manifest)
+	}
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkContents(t, bc, want)
+}
+
+func TestMigrateBaselineReadOnly(t *testing.T) {
+	dir := copyDir(t, path.Join("testdata", "baseline"))
+	dataFiles := func() string {
+		return strings.Join(append(listFiles(t, dir, ".data"), listFiles(t, dir, ".hint")...), " ")
+	}
+	before := dataFiles()
+
+	_, err := Open(dir, WithReadOnly())
+	if err == nil {
+		t.Fatal("Open of a baseline datastore as ReadOnly succeeded")
+	}
+	if after := dataFiles(); after != before {
+		t.Fatalf("got files %v after the failed Open, want %v", after, before)
+	}
+}
+
+func TestMigrateUnknownFormat(t *testing.T) {
+	dir := copyDir(t, path.Join("testdata", "baseline"))
+	garbage := bytes.Repeat([]byte{0xab}, 100)
+	writeFile(t, path.Join(dir, "3000.data"), garbage)
+
+	_, err := Open(dir, WithReadWrite())
+	if err == nil {
+		t.Fatal("Open of a datastore with a data file of an unknown format succeeded")
+	}
+
+	data, err := os.ReadFile(path.Join(dir, "3000.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, garbage) {
+		t.Fatal("the data file of an unknown format is modified")
+	}
+	if fileExists(path.Join(dir, "MANIFEST")) {
+		t.Fatal("a manifest is written for a datastore of an unknown format")
+	}
+}
@@ -0,0 +1,118 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// errLenMismatch happens whenever MultiPutBytes is given a different number of keys and values.
+var errLenMismatch = errors.New("number of keys and values does not match")
+
+// fileReads groups the records read from a single data file.
+type fileReads struct {
+	keys [][]byte
+	recs []recfmt.KeyDirRec
+}
+
+// MultiGet reads the values of the given keys, the keys that do not exist or have expired are absent from the result.
+// The reads are grouped by data file and done in the order of the records within each file,
+// so every data file is opened once.
+func (bitcask *Bitcask) MultiGet(keys []string) (map[string]string, error) {
+	byteKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		byteKeys[i] = []byte(key)
+	}
+
+	values, err := bitcask.MultiGetBytes(byteKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]string, len(values))
+	for key, value := range values {
+		res[key] = string(value)
+	}
+
+	return res, nil
+}
+
+// MultiGetBytes is the binary-safe version of MultiGet.
+func (bitcask *Bitcask) MultiGetBytes(keys [][]byte) (map[string][]byte, error) {
+	bitcask.accessMu.RLock()
+	defer bitcask.accessMu.RUnlock()
+
+	files := make(map[string]*fileReads)
+	now := time.Now().UnixMicro()
+	for _, key := range keys {
+		rec, isExist := bitcask.keyDir.Get(string(key))
+		if !isExist || rec.IsExpired(now) {
+			continue
+		}
+
+		reads, ok := files[rec.FileId]
+		if !ok {
+			reads = &fileReads{}
+			files[rec.FileId] = reads
+		}
+		reads.keys = append(reads.keys, key)
+		reads.recs = append(reads.recs, rec)
+	}
+
+	res := make(map[string][]byte, len(keys))
+	for fileId, reads := range files {
+		sort.Sort(reads)
+		values, err := bitcask.dataStore.ReadValuesFromFile(fileId, reads.keys, reads.recs)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range reads.keys {
+			res[string(key)] = values[i]
+		}
+	}
+
+	return res, nil
+}
+
+// MultiPut stores the given key/value pairs, all their records are appended to the active file in a single write.
+// The pairs are stored atomically like the writes of a Batch.
+func (bitcask *Bitcask) MultiPut(pairs map[string]string) error {
+	keys := make([][]byte, 0, len(pairs))
+	values := make([][]byte, 0, len(pairs))
+	for key, value := range pairs {
+		keys = append(keys, []byte(key))
+		values = append(values, []byte(value))
+	}
+
+	return bitcask.MultiPutBytes(keys, values)
+}
+
+// MultiPutBytes is the binary-safe version of MultiPut, the i-th key is stored with the i-th value.
+func (bitcask *Bitcask) MultiPutBytes(keys, values [][]byte) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("%d keys and %d values: %s", len(keys), len(values), errLenMismatch)
+	}
+
+	batch := NewBatch()
+	for i, key := range keys {
+		batch.PutBytes(key, values[i])
+	}
+
+	return bitcask.Write(batch)
+}
+
+func (reads *fileReads) Len() int {
+	return len(reads.keys)
+}
+
+func (reads *fileReads) Less(i, j int) bool {
+	return reads.recs[i].ValuePos < reads.recs[j].ValuePos
+}
+
+func (reads *fileReads) Swap(i, j int) {
+	reads.keys[i], reads.keys[j] = reads.keys[j], reads.keys[i]
+	reads.recs[i], reads.recs[j] = reads.recs[j], reads.recs[i]
+}
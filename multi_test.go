@@ -0,0 +1,156 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+func TestMultiGet(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir, WithMaxFileSize(256))
+	want := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		if err := bc.Put(key, "old "+key); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			if err := bc.Put(key, "new "+key); err != nil {
+				t.Fatal(err)
+			}
+			want[key] = "new " + key
+		} else {
+			want[key] = "old " + key
+		}
+	}
+	if err := bc.PutWithTTL("expired", "value", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("deleted", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+	time.Sleep(2 * ttl)
+
+	bc = openStore(t, dir, WithMaxFileSize(256))
+	defer bc.Close()
+	files := make(map[string]bool)
+	for key := range want {
+		rec, _ := bc.keyDir.Get(key)
+		files[rec.FileId] = true
+	}
+	if len(files) < 3 {
+		t.Fatalf("the keys are in %d data files, want several", len(files))
+	}
+
+	// the keys are asked for in the reverse order of their records, mixed with keys that are not found.
+	keys := []string{"missing", "expired"}
+	for i := 29; i >= 0; i-- {
+		keys = append(keys, fmt.Sprintf("key%02d", i))
+		if i == 15 {
+			keys = append(keys, "deleted", "key15")
+		}
+	}
+	got, err := bc.MultiGet(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	got, err = bc.MultiGet([]string{"missing", "deleted", "expired"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("got %v for keys that are not found, want nothing", got)
+	}
+}
+
+func TestMultiPut(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir)
+	defer bc.Close()
+	if err := bc.Put("before", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	files := listFiles(t, dir, ".data")
+	name := path.Join(dir, files[0])
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := info.Size()
+
+	pairs := make(map[string]string)
+	recsSize := 2 * recfmt.DataFileHdrSize
+	for i := 0; i < 20; i++ {
+		key, value := fmt.Sprintf("key%02d", i), fmt.Sprintf("value%02d", i)
+		pairs[key] = value
+		recsSize += recfmt.DataFileHdrSize + len(key) + len(value)
+	}
+	if err := bc.MultiPut(pairs); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pairs are appended to the active file as one batch: a begin record, the records of the pairs
+	// and a single commit record.
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listFiles(t, dir, ".data")) != 1 || len(data)-int(before) != recsSize {
+		t.Fatalf("MultiPut appended %d bytes, want %d to the active file", len(data)-int(before), recsSize)
+	}
+	data = data[before:]
+	puts := 0
+	for i := 0; len(data) > 0; i++ {
+		rec, n, err := recfmt.ExtractDataFileRec(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+		switch {
+		case i == 0 && !rec.IsBatchBegin():
+			t.Fatal("the appended records do not start with a batch begin record")
+		case len(data) == 0 && !rec.IsBatchCommit():
+			t.Fatal("the appended records do not end with a batch commit record")
+		case i != 0 && len(data) != 0:
+			if rec.IsBatchBegin() || rec.IsBatchCommit() {
+				t.Fatal("got a batch record among the records of the pairs")
+			}
+			puts++
+		}
+	}
+	if puts != len(pairs) {
+		t.Fatalf("got %d records between the batch records, want %d", puts, len(pairs))
+	}
+
+	got, err := bc.MultiGet([]string{"before", "key00", "key19"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"before": "value", "key00": "value00", "key19": "value19"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if err := bc.MultiPutBytes([][]byte{[]byte("a")}, nil); err == nil || !strings.Contains(err.Error(), errLenMismatch.Error()) {
+		t.Fatalf("got error %v, want %v", err, errLenMismatch)
+	}
+}
@@ -0,0 +1,131 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Eslam-Nawara/bitcask/internal/keydir"
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// errInvalidOpt happens when Open is given an option with an unknown or invalid value.
+var errInvalidOpt = errors.New("invalid config option")
+
+type (
+	// Option configures the bitcask datastore opened by Open.
+	// Both the ConfigOpt constants and the With* functions are options.
+	Option interface {
+		apply(*options) error
+	}
+
+	// optionFunc adapts a function to the Option interface.
+	optionFunc func(*options) error
+)
+
+func (fn optionFunc) apply(usrOpts *options) error {
+	return fn(usrOpts)
+}
+
+func (opt ConfigOpt) apply(usrOpts *options) error {
+	switch opt {
+	case ReadOnly, ReadWrite:
+		if usrOpts.accessSet && usrOpts.accessPermission != opt {
+			return fmt.Errorf("conflicting access permissions: %s", errInvalidOpt)
+		}
+		usrOpts.accessPermission, usrOpts.accessSet = opt, true
+	case SyncOnPut, SyncOnDemand:
+		return setSyncPolicy(usrOpts, opt)
+	default:
+		return fmt.Errorf("%d: %s", opt, errInvalidOpt)
+	}
+
+	return nil
+}
+
+// setSyncPolicy sets the given sync policy, returns an error if a different one is already given.
+func setSyncPolicy(usrOpts *options, policy ConfigOpt) error {
+	if usrOpts.syncSet && usrOpts.syncOption != policy {
+		return fmt.Errorf("conflicting sync policies: %s", errInvalidOpt)
+	}
+	usrOpts.syncOption, usrOpts.syncSet = policy, true
+
+	return nil
+}
+
+// WithReadOnly gives the bitcask process a read only permission, it is the same as ReadOnly.
+func WithReadOnly() Option {
+	return ReadOnly
+}
+
+// WithReadWrite gives the bitcask process read and write permissions, it is the same as ReadWrite.
+func WithReadWrite() Option {
+	return ReadWrite
+}
+
+// WithSyncPolicy sets when the writes are flushed to the disk, policy is either SyncOnPut or SyncOnDemand.
+func WithSyncPolicy(policy ConfigOpt) Option {
+	return optionFunc(func(usrOpts *options) error {
+		if policy != SyncOnPut && policy != SyncOnDemand {
+			return fmt.Errorf("sync policy %d: %s", policy, errInvalidOpt)
+		}
+		return setSyncPolicy(usrOpts, policy)
+	})
+}
+
+// WithMaxFileSize sets the size in bytes after which the active data file is rotated.
+// A single write must fit in a data file, so it also bounds the size of the values and batches.
+func WithMaxFileSize(size int64) Option {
+	return optionFunc(func(usrOpts *options) error {
+		if size < recfmt.DataFileHdrSize {
+			return fmt.Errorf("max file size %d: %s", size, errInvalidOpt)
+		}
+		usrOpts.maxFileSize = size
+		return nil
+	})
+}
+
+// WithOrderedKeyDir keeps the keys of the in-memory keydir sorted, which makes Scan, Range
+// and the ordered iterations cheap at the cost of slower writes and lookups than the default hash keydir.
+func WithOrderedKeyDir() Option {
+	return optionFunc(func(usrOpts *options) error {
+		usrOpts.keyDirType = keydir.OrderedKeyDir
+		return nil
+	})
+}
+
+// WithCompression compresses the written values of at least threshold bytes with DEFLATE,
+// a value is stored uncompressed if compressing it does not save space.
+// The reads decompress the values transparently, and Merge compresses or decompresses the merged values
+// to follow the current setting.
+func WithCompression(threshold int) Option {
+	return optionFunc(func(usrOpts *options) error {
+		if threshold <= 0 {
+			return fmt.Errorf("compression threshold %d: %s", threshold, errInvalidOpt)
+		}
+		usrOpts.compressThreshold = threshold
+		return nil
+	})
+}
+
+// WithFileMode sets the permission bits used to create the datastore files.
+func WithFileMode(mode os.FileMode) Option {
+	return optionFunc(func(usrOpts *options) error {
+		if mode&^os.ModePerm != 0 {
+			return fmt.Errorf("file mode %s: %s", mode, errInvalidOpt)
+		}
+		usrOpts.fileMode = mode
+		return nil
+	})
+}
+
+// WithDirMode sets the permission bits used to create the datastore directory.
+func WithDirMode(mode os.FileMode) Option {
+	return optionFunc(func(usrOpts *options) error {
+		if mode&^os.ModePerm != 0 {
+			return fmt.Errorf("dir mode %s: %s", mode, errInvalidOpt)
+		}
+		usrOpts.dirMode = mode
+		return nil
+	})
+}
@@ -0,0 +1,54 @@
+package bitcask
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// checkOpenFails checks that Open with the given options fails with the given error, and creates no datastore.
+func checkOpenFails(t *testing.T, want error, opts ...Option) {
+	t.Helper()
+
+	dir := t.TempDir()
+	bc, err := Open(dir, opts...)
+	if err == nil {
+		bc.Close()
+		t.Fatal("Open succeeded")
+	}
+	if !strings.Contains(err.Error(), want.Error()) {
+		t.Fatalf("got error %q, want %q", err, want)
+	}
+	if files := listFiles(t, dir, ""); len(files) != 0 {
+		t.Fatalf("the failed Open created files %v", files)
+	}
+}
+
+func TestMaxFileSizeBelowHeader(t *testing.T) {
+	checkOpenFails(t, errInvalidOpt, WithReadWrite(), WithMaxFileSize(recfmt.DataFileHdrSize-1))
+
+	bc := openStore(t, t.TempDir(), WithMaxFileSize(recfmt.DataFileHdrSize))
+	bc.Close()
+}
+
+func TestConflictingSyncOptions(t *testing.T) {
+	checkOpenFails(t, errInvalidOpt, ReadWrite, SyncOnPut, SyncOnDemand)
+	checkOpenFails(t, errInvalidOpt, ReadWrite, WithSyncPolicy(SyncOnDemand), WithSyncPolicy(SyncOnPut))
+	checkOpenFails(t, errInvalidOpt, ReadWrite, SyncOnPut, WithSyncPolicy(SyncOnDemand))
+	checkOpenFails(t, errInvalidOpt, ReadWrite, WithSyncPolicy(ConfigOpt(100)))
+
+	// repeating the same policy is not a conflict.
+	bc := openStore(t, t.TempDir(), SyncOnPut, WithSyncPolicy(SyncOnPut))
+	bc.Close()
+}
+
+func TestConflictingAccessOptions(t *testing.T) {
+	checkOpenFails(t, errInvalidOpt, ReadWrite, WithReadOnly())
+}
+
+func TestReadOnlyMergePolicy(t *testing.T) {
+	checkOpenFails(t, errRequireWrite, WithReadOnly(), WithMergePolicy(MergePolicy{}))
+	checkOpenFails(t, errRequireWrite, WithMergePolicy(MergePolicy{}))
+	checkOpenFails(t, errInvalidOpt, WithReadWrite(), WithMergePolicy(MergePolicy{MinFragmentation: 2}))
+}
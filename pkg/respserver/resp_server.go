@@ -2,12 +2,16 @@ package respserver
 
 import (
 	"errors"
+	"strconv"
 
 	"github.com/Eslam-Nawara/bitcask"
 	"github.com/tidwall/resp"
 )
 
-var errInvalidArgsNum = errors.New("invalid number of arguments passed")
+var (
+	errInvalidArgsNum = errors.New("invalid number of arguments passed")
+	errInvalidInteger = errors.New("value is not an integer or out of range")
+)
 
 type RespServer struct {
 	port         string
@@ -48,6 +52,10 @@ func (server *RespServer) registerHandlers() {
 	server.server.HandleFunc("set", server.set)
 	server.server.HandleFunc("get", server.get)
 	server.server.HandleFunc("del", server.del)
+	server.server.HandleFunc("incr", server.incr)
+	server.server.HandleFunc("incrby", server.incrBy)
+	server.server.HandleFunc("decr", server.decr)
+	server.server.HandleFunc("decrby", server.decrBy)
 }
 
 func (server *RespServer) set(conn *resp.Conn, args []resp.Value) bool {
@@ -57,8 +65,9 @@ func (server *RespServer) set(conn *resp.Conn, args []resp.Value) bool {
 		err := server.bitcask.Put(args[1].String(), args[2].String())
 		if err != nil {
 			conn.WriteError(err)
+		} else {
+			conn.WriteSimpleString("OK")
 		}
-		conn.WriteSimpleString("OK")
 	}
 
 	return true
@@ -93,3 +102,65 @@ func (server *RespServer) del(conn *resp.Conn, args []resp.Value) bool {
 
 	return true
 }
+
+func (server *RespServer) incr(conn *resp.Conn, args []resp.Value) bool {
+	if len(args) != 2 {
+		conn.WriteError(errInvalidArgsNum)
+	} else {
+		counter, err := server.bitcask.Incr(args[1].String(), 1)
+		writeCounter(conn, counter, err)
+	}
+
+	return true
+}
+
+func (server *RespServer) incrBy(conn *resp.Conn, args []resp.Value) bool {
+	if len(args) != 3 {
+		conn.WriteError(errInvalidArgsNum)
+	} else if delta, err := strconv.ParseInt(args[2].String(), 10, 64); err != nil {
+		conn.WriteError(errInvalidInteger)
+	} else {
+		counter, err := server.bitcask.Incr(args[1].String(), delta)
+		writeCounter(conn, counter, err)
+	}
+
+	return true
+}
+
+func (server *RespServer) decr(conn *resp.Conn, args []resp.Value) bool {
+	if len(args) != 2 {
+		conn.WriteError(errInvalidArgsNum)
+	} else {
+		counter, err := server.bitcask.Decr(args[1].String(), 1)
+		writeCounter(conn, counter, err)
+	}
+
+	return true
+}
+
+func (server *RespServer) decrBy(conn *resp.Conn, args []resp.Value) bool {
+	if len(args) != 3 {
+		conn.WriteError(errInvalidArgsNum)
+	} else if delta, err := strconv.ParseInt(args[2].String(), 10, 64); err != nil {
+		conn.WriteError(errInvalidInteger)
+	} else {
+		counter, err := server.bitcask.Decr(args[1].String(), delta)
+		writeCounter(conn, counter, err)
+	}
+
+	return true
+}
+
+// writeCounter replies with the new value of a counter or the error of its operation.
+// The resp integers are limited to int, so a counter not fitting in it is replied as its base 10 string,
+// which only happens on 32-bit platforms.
+func writeCounter(conn *resp.Conn, counter int64, err error) {
+	switch {
+	case err != nil:
+		conn.WriteError(err)
+	case int64(int(counter)) != counter:
+		conn.WriteString(strconv.FormatInt(counter, 10))
+	default:
+		conn.WriteInteger(int(counter))
+	}
+}
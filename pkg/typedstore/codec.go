@@ -0,0 +1,63 @@
+package typedstore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+type (
+	// Codec converts the values of a Store to and from their stored bytes.
+	Codec[V any] interface {
+		// Encode returns the stored bytes of the given value.
+		Encode(value V) ([]byte, error)
+		// Decode decodes the given stored bytes into value.
+		Decode(data []byte, value *V) error
+	}
+
+	// JSONCodec stores the values as JSON.
+	JSONCodec[V any] struct{}
+
+	// GobCodec stores the values in the gob format, every value carries its own type information.
+	GobCodec[V any] struct{}
+
+	// RawCodec stores byte slice values as they are.
+	RawCodec struct{}
+)
+
+// Encode returns the JSON encoding of the given value.
+func (JSONCodec[V]) Encode(value V) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Decode decodes the given JSON into value.
+func (JSONCodec[V]) Decode(data []byte, value *V) error {
+	return json.Unmarshal(data, value)
+}
+
+// Encode returns the gob encoding of the given value.
+func (GobCodec[V]) Encode(value V) ([]byte, error) {
+	var buff bytes.Buffer
+	err := gob.NewEncoder(&buff).Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// Decode decodes the given gob encoding into value.
+func (GobCodec[V]) Decode(data []byte, value *V) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// Encode returns the given value.
+func (RawCodec) Encode(value []byte) ([]byte, error) {
+	return value, nil
+}
+
+// Decode sets value to the given data.
+func (RawCodec) Decode(data []byte, value *[]byte) error {
+	*value = data
+	return nil
+}
@@ -0,0 +1,152 @@
+// Package typedstore provides a typed view over a bitcask datastore.
+package typedstore
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask"
+)
+
+type (
+	// Key is the type set of the Store keys, they are stored as their bytes so the ordered iterations
+	// visit them in the same order of bitcask.
+	Key interface {
+		~string | ~[]byte
+	}
+
+	// Store wraps a bitcask datastore whose values are all of type V, converting them with a Codec.
+	// The Store uses the same datastore files, so the bitcask can still be used directly.
+	Store[K Key, V any] struct {
+		bitcask *bitcask.Bitcask
+		codec   Codec[V]
+	}
+
+	// Iterator is a typed cursor over the key/value pairs of a snapshot of the Store,
+	// it is used the same way as bitcask.Iterator.
+	Iterator[K Key, V any] struct {
+		it    *bitcask.Iterator
+		codec Codec[V]
+		key   K
+		value V
+		err   error
+	}
+)
+
+// New returns a store of the given bitcask, its values are converted by the given codec.
+func New[K Key, V any](bitcask *bitcask.Bitcask, codec Codec[V]) *Store[K, V] {
+	return &Store[K, V]{bitcask: bitcask, codec: codec}
+}
+
+// Bitcask returns the wrapped bitcask datastore.
+func (store *Store[K, V]) Bitcask() *bitcask.Bitcask {
+	return store.bitcask
+}
+
+// Get reads the value of the given key.
+func (store *Store[K, V]) Get(key K) (V, error) {
+	var value V
+
+	data, err := store.bitcask.GetBytes([]byte(key))
+	if err != nil {
+		return value, err
+	}
+
+	err = store.codec.Decode(data, &value)
+	if err != nil {
+		return value, fmt.Errorf("%s: %s", []byte(key), err)
+	}
+
+	return value, nil
+}
+
+// Put stores the given key and value.
+func (store *Store[K, V]) Put(key K, value V) error {
+	data, err := store.codec.Encode(value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", []byte(key), err)
+	}
+
+	return store.bitcask.PutBytes([]byte(key), data)
+}
+
+// PutWithTTL stores the given key and value which expire after the given time to live.
+func (store *Store[K, V]) PutWithTTL(key K, value V, ttl time.Duration) error {
+	data, err := store.codec.Encode(value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", []byte(key), err)
+	}
+
+	return store.bitcask.PutBytesWithTTL([]byte(key), data, ttl)
+}
+
+// Delete removes the given key.
+func (store *Store[K, V]) Delete(key K) error {
+	return store.bitcask.DeleteBytes([]byte(key))
+}
+
+// Iterator returns an iterator over a snapshot of the store taken at the time of the call,
+// it accepts the options of bitcask.Iterator. The iterator must be closed when no longer needed.
+func (store *Store[K, V]) Iterator(opts ...bitcask.IterOption) *Iterator[K, V] {
+	return &Iterator[K, V]{it: store.bitcask.Iterator(opts...), codec: store.codec}
+}
+
+// Scan returns an iterator over the keys starting with the given prefix in ascending order.
+func (store *Store[K, V]) Scan(prefix K, opts ...bitcask.IterOption) *Iterator[K, V] {
+	return store.Iterator(append(opts, bitcask.IterPrefix([]byte(prefix)))...)
+}
+
+// Next advances the iterator to the next key/value pair.
+// Returns false when there are no more pairs or an error happens, the error is reported by Err.
+func (it *Iterator[K, V]) Next() bool {
+	var zeroKey K
+	var zeroValue V
+	it.key, it.value = zeroKey, zeroValue
+
+	if it.err != nil || !it.it.Next() {
+		return false
+	}
+
+	it.key = K(it.it.Key())
+	if it.it.Value() == nil {
+		return true
+	}
+
+	err := it.codec.Decode(it.it.Value(), &it.value)
+	if err != nil {
+		it.err = fmt.Errorf("%s: %s", it.it.Key(), err)
+		it.key, it.value = zeroKey, zeroValue
+		return false
+	}
+
+	return true
+}
+
+// Key returns the key of the current pair.
+func (it *Iterator[K, V]) Key() K {
+	return it.key
+}
+
+// Value returns the value of the current pair, it is always the zero value for key only iterators.
+func (it *Iterator[K, V]) Value() V {
+	return it.value
+}
+
+// Err returns the error that stopped the iteration if any, such as a failed read or decode.
+func (it *Iterator[K, V]) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+
+	return it.it.Err()
+}
+
+// Close releases the iterator.
+func (it *Iterator[K, V]) Close() error {
+	err := it.it.Close()
+	if it.err != nil {
+		return it.err
+	}
+
+	return err
+}
@@ -0,0 +1,166 @@
+package typedstore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Eslam-Nawara/bitcask"
+)
+
+// user is the value type stored by the tests.
+type user struct {
+	Name   string
+	Age    int
+	Emails []string
+	Tags   map[string]bool
+}
+
+var users = map[string]user{
+	"user:1": {Name: "first", Age: 30, Emails: []string{"first@example.com"}, Tags: map[string]bool{"admin": true}},
+	"user:2": {Name: "second", Age: 0},
+	"user:3": {Name: "", Age: -1, Emails: []string{"a", "b"}},
+}
+
+func openBitcask(t *testing.T, dir string) *bitcask.Bitcask {
+	t.Helper()
+
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithOrderedKeyDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bc
+}
+
+// checkRoundTrip puts the given values through a store of the given codec, then reads them back
+// before and after reopening the datastore.
+func checkRoundTrip[V any](t *testing.T, codec Codec[V], values map[string]V) {
+	t.Helper()
+
+	dir := t.TempDir()
+	bc := openBitcask(t, dir)
+	store := New[string, V](bc, codec)
+	for key, value := range values {
+		if err := store.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkValues(t, store, values)
+	bc.Close()
+
+	bc = openBitcask(t, dir)
+	defer bc.Close()
+	checkValues(t, New[string, V](bc, codec), values)
+}
+
+func checkValues[V any](t *testing.T, store *Store[string, V], values map[string]V) {
+	t.Helper()
+
+	for key, want := range values {
+		got, err := store.Get(key)
+		if err != nil {
+			t.Fatalf("Get(%q): %s", key, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Get(%q): got %+v, want %+v", key, got, want)
+		}
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	checkRoundTrip[user](t, JSONCodec[user]{}, users)
+}
+
+func TestGobCodec(t *testing.T) {
+	checkRoundTrip[user](t, GobCodec[user]{}, users)
+	checkRoundTrip[map[string]int](t, GobCodec[map[string]int]{}, map[string]map[string]int{"a": {"x": 1, "y": -2}})
+}
+
+func TestRawCodec(t *testing.T) {
+	checkRoundTrip[[]byte](t, RawCodec{}, map[string][]byte{
+		"binary": {0x00, 0xff, 0xfe, 0x00},
+		"text":   []byte("plain text"),
+	})
+}
+
+func TestBucketIterator(t *testing.T) {
+	bc := openBitcask(t, t.TempDir())
+	defer bc.Close()
+
+	bucket, err := bc.Bucket("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := New[string, user](bucket, JSONCodec[user]{})
+	for key, value := range users {
+		if err := store.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bucket.Put("other:1", `{"Name": "other"}`); err != nil {
+		t.Fatal(err)
+	}
+	// the keys of the default bucket are not visited.
+	if err := bc.Put("user:0", `{"Name": "default bucket"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	it := store.Scan("user:")
+	visited := make([]string, 0)
+	for it.Next() {
+		if !reflect.DeepEqual(it.Value(), users[it.Key()]) {
+			t.Fatalf("%s: got %+v, want %+v", it.Key(), it.Value(), users[it.Key()])
+		}
+		visited = append(visited, it.Key())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"user:1", "user:2", "user:3"}; !reflect.DeepEqual(visited, want) {
+		t.Fatalf("visited %v, want %v", visited, want)
+	}
+
+	keysOnly := store.Iterator(bitcask.IterKeysOnly(), bitcask.IterReverse())
+	visited = visited[:0]
+	for keysOnly.Next() {
+		visited = append(visited, keysOnly.Key())
+	}
+	if err := keysOnly.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"user:3", "user:2", "user:1", "other:1"}; !reflect.DeepEqual(visited, want) {
+		t.Fatalf("visited %v, want %v", visited, want)
+	}
+}
+
+func TestIteratorDecodeError(t *testing.T) {
+	bc := openBitcask(t, t.TempDir())
+	defer bc.Close()
+
+	store := New[string, user](bc, JSONCodec[user]{})
+	if err := store.Put("a", users["user:1"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("b", "not json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("b"); err == nil {
+		t.Fatal("Get of an invalid value succeeded")
+	}
+
+	it := store.Iterator(bitcask.IterRange([]byte("a"), nil))
+	defer it.Close()
+	if !it.Next() || it.Key() != "a" {
+		t.Fatalf("got key %q, want a", it.Key())
+	}
+	if it.Next() {
+		t.Fatal("Next decoded an invalid value")
+	}
+	if err := it.Err(); err == nil || !strings.Contains(err.Error(), "b") {
+		t.Fatalf("got error %v, want the decode error of b", err)
+	}
+	if !reflect.DeepEqual(it.Value(), user{}) {
+		t.Fatalf("got value %+v after the error, want the zero value", it.Value())
+	}
+}
@@ -0,0 +1,99 @@
+package bitcask
+
+import (
+	"sort"
+
+	"github.com/Eslam-Nawara/bitcask/internal/keydir"
+)
+
+type (
+	// RecoveryReport describes the damaged data files found by Open, only the valid records
+	// before the damage of every file are loaded.
+	// The data files merged by Merge are not checked as they are loaded from their hint files.
+	RecoveryReport struct {
+		// Repaired reports whether the damaged files are repaired on the disk, which requires write permission.
+		// The files are left intact when the datastore is opened as ReadOnly.
+		Repaired bool
+		// TruncatedFiles holds the data files ending with a torn write, too short to hold a record header or zeroed,
+		// the torn write is removed from the file.
+		TruncatedFiles []RecoveredFile
+		// QuarantinedFiles holds the other damaged data files, whose invalid data may hide valid records
+		// behind a corrupted size, the file is copied to the quarantine subdirectory of the datastore
+		// then truncated before the invalid data.
+		QuarantinedFiles []RecoveredFile
+	}
+
+	// RecoveredFile describes a damaged data file.
+	RecoveredFile struct {
+		// Name is the name of the data file.
+		Name string
+		// ValidBytes is the size of the valid records kept at the start of the file.
+		ValidBytes int64
+		// LostBytes is the size of the data after the valid records, which is dropped.
+		LostBytes int64
+	}
+)
+
+// OpenWithReport opens the datastore at the given path like Open, and returns the report of the damaged data files
+// found and repaired while opening it.
+// The report is returned by this variant rather than by Open, so that Open keeps its signature,
+// it is also available later from RecoveryReport.
+func OpenWithReport(dataStorePath string, opts ...Option) (*Bitcask, RecoveryReport, error) {
+	bitcask, err := Open(dataStorePath, opts...)
+	if err != nil {
+		return nil, RecoveryReport{}, err
+	}
+
+	return bitcask, bitcask.recovery, nil
+}
+
+// RecoveryReport returns the report of the damaged data files found when the datastore was opened.
+func (bitcask *Bitcask) RecoveryReport() RecoveryReport {
+	return bitcask.recovery
+}
+
+// IsClean reports whether no damaged data files were found.
+func (report RecoveryReport) IsClean() bool {
+	return len(report.TruncatedFiles) == 0 && len(report.QuarantinedFiles) == 0
+}
+
+// recoverFiles reports the damaged data files among the given files, and repairs them if opened with write permission:
+// the files ending with a torn write are truncated, and the other damaged files are quarantined.
+// the size of every repaired file is set to the size of its valid records.
+func (bitcask *Bitcask) recoverFiles(files map[string]keydir.FileStats) error {
+	bitcask.recovery.Repaired = bitcask.usrOpts.accessPermission == ReadWrite
+
+	names := make([]string, 0)
+	for name, file := range files {
+		if file.ValidSize < file.Size {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		file := files[name]
+		recovered := RecoveredFile{Name: name, ValidBytes: file.ValidSize, LostBytes: file.Size - file.ValidSize}
+
+		if bitcask.recovery.Repaired {
+			repair := bitcask.dataStore.QuarantineFile
+			if file.Torn {
+				repair = bitcask.dataStore.TruncateFile
+			}
+			err := repair(name, file.ValidSize)
+			if err != nil {
+				return err
+			}
+			file.Size = file.ValidSize
+			files[name] = file
+		}
+
+		if file.Torn {
+			bitcask.recovery.TruncatedFiles = append(bitcask.recovery.TruncatedFiles, recovered)
+		} else {
+			bitcask.recovery.QuarantinedFiles = append(bitcask.recovery.QuarantinedFiles, recovered)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,289 @@
+package bitcask
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// damagedStore is a closed datastore of a single data file, whose records are damaged by the tests.
+type damagedStore struct {
+	dir, file string
+	// data is the data file as written, and offsets are the positions of its records.
+	data    []byte
+	offsets []int
+}
+
+func newDamagedStore(t *testing.T) *damagedStore {
+	t.Helper()
+
+	store := &damagedStore{dir: t.TempDir()}
+	bc := openStore(t, store.dir)
+	for i := 0; i < 10; i++ {
+		if err := bc.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bc.Close()
+
+	files := listFiles(t, store.dir, ".data")
+	if len(files) != 1 {
+		t.Fatalf("got data files %v, want a single one", files)
+	}
+	store.file = files[0]
+
+	var err error
+	store.data, err = os.ReadFile(path.Join(store.dir, store.file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(store.data); i += int(recfmt.DataFileRecLen(store.data[i:])) {
+		store.offsets = append(store.offsets, i)
+	}
+	if len(store.offsets) != 10 {
+		t.Fatalf("got %d records, want 10", len(store.offsets))
+	}
+
+	return store
+}
+
+// write replaces the data file with the given data.
+func (store *damagedStore) write(t *testing.T, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path.Join(store.dir, store.file), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// contents returns the contents of the first given number of records.
+func (store *damagedStore) contents(records int) map[string]string {
+	want := make(map[string]string)
+	for i := 0; i < records; i++ {
+		want[fmt.Sprintf("key%d", i)] = fmt.Sprintf("value%d", i)
+	}
+
+	return want
+}
+
+// checkRepair checks the size of the repaired data file, and its copy in the quarantine directory if any.
+func (store *damagedStore) checkRepair(t *testing.T, damaged []byte, validSize int, quarantined bool) {
+	t.Helper()
+
+	info, err := os.Stat(path.Join(store.dir, store.file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(validSize) {
+		t.Fatalf("got a repaired file of %d bytes, want %d", info.Size(), validSize)
+	}
+
+	copied, err := os.ReadFile(path.Join(store.dir, "quarantine", store.file))
+	if !quarantined {
+		if err == nil {
+			t.Fatal("a torn file is quarantined")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("the quarantined copy: %s", err)
+	}
+	if !bytes.Equal(copied, damaged) {
+		t.Fatal("the quarantined copy differs from the damaged file")
+	}
+}
+
+// checkReport checks that the given report holds only the given damaged file.
+func checkReport(t *testing.T, report RecoveryReport, want RecoveredFile, quarantined bool) {
+	t.Helper()
+
+	files, other := report.TruncatedFiles, report.QuarantinedFiles
+	if quarantined {
+		files, other = other, files
+	}
+	if len(files) != 1 || len(other) != 0 || files[0] != want {
+		t.Fatalf("got recovery report %+v, want %+v quarantined %v", report, want, quarantined)
+	}
+}
+
+func TestRecoverTornTail(t *testing.T) {
+	store := newDamagedStore(t)
+	// the last record is cut within its header.
+	damaged := store.data[:store.offsets[9]+recfmt.DataFileHdrSize-1]
+	store.write(t, damaged)
+
+	bc, report, err := OpenWithReport(store.dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	valid := store.offsets[9]
+	checkReport(t, report, RecoveredFile{Name: store.file, ValidBytes: int64(valid), LostBytes: int64(len(damaged) - valid)}, false)
+	if !report.Repaired {
+		t.Fatal("the report is not repaired")
+	}
+	store.checkRepair(t, damaged, valid, false)
+	checkContents(t, bc, store.contents(9))
+}
+
+func TestRecoverZeroedTail(t *testing.T) {
+	store := newDamagedStore(t)
+	// the file grew before the last record was written, as some filesystems do on a crash.
+	damaged := append(append([]byte{}, store.data...), make([]byte, 4096)...)
+	store.write(t, damaged)
+
+	bc, report, err := OpenWithReport(store.dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	checkReport(t, report, RecoveredFile{Name: store.file, ValidBytes: int64(len(store.data)), LostBytes: 4096}, false)
+	store.checkRepair(t, damaged, len(store.data), false)
+	checkContents(t, bc, store.contents(10))
+}
+
+func TestRecoverIncompleteLastRecord(t *testing.T) {
+	store := newDamagedStore(t)
+	// the header of the last record is complete, so its sizes can not be told from a corruption.
+	damaged := store.data[:len(store.data)-1]
+	store.write(t, damaged)
+
+	bc, report, err := OpenWithReport(store.dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	valid := store.offsets[9]
+	checkReport(t, report, RecoveredFile{Name: store.file, ValidBytes: int64(valid), LostBytes: int64(len(damaged) - valid)}, true)
+	store.checkRepair(t, damaged, valid, true)
+	checkContents(t, bc, store.contents(9))
+}
+
+func TestRecoverCorruptedSize(t *testing.T) {
+	store := newDamagedStore(t)
+	// the value size of a record in the middle claims more than the rest of the file,
+	// hiding the valid records after it.
+	damaged := append([]byte{}, store.data...)
+	binary.LittleEndian.PutUint32(damaged[store.offsets[4]+32:], 1<<20)
+	store.write(t, damaged)
+
+	bc, report, err := OpenWithReport(store.dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	valid := store.offsets[4]
+	checkReport(t, report, RecoveredFile{Name: store.file, ValidBytes: int64(valid), LostBytes: int64(len(damaged) - valid)}, true)
+	store.checkRepair(t, damaged, valid, true)
+	checkContents(t, bc, store.contents(4))
+}
+
+func TestRecoverCorruptedRecord(t *testing.T) {
+	store := newDamagedStore(t)
+	damaged := append([]byte{}, store.data...)
+	damaged[store.offsets[4]+recfmt.DataFileHdrSize] ^= 0xff
+	store.write(t, damaged)
+
+	bc, report, err := OpenWithReport(store.dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	valid := store.offsets[4]
+	checkReport(t, report, RecoveredFile{Name: store.file, ValidBytes: int64(valid), LostBytes: int64(len(damaged) - valid)}, true)
+	store.checkRepair(t, damaged, valid, true)
+	checkContents(t, bc, store.contents(4))
+}
+
+func TestRecoverReadOnly(t *testing.T) {
+	store := newDamagedStore(t)
+	damaged := append([]byte{}, store.data...)
+	damaged[store.offsets[4]+recfmt.DataFileHdrSize] ^= 0xff
+	store.write(t, damaged)
+
+	// the second open finds the damage again, as the keydir file is not shared for damaged data files.
+	for i := 0; i < 2; i++ {
+		bc, report, err := OpenWithReport(store.dir, WithReadOnly())
+		if err != nil {
+			t.Fatal(err)
+		}
+		valid := store.offsets[4]
+		checkReport(t, report, RecoveredFile{Name: store.file, ValidBytes: int64(valid), LostBytes: int64(len(damaged) - valid)}, true)
+		if report.Repaired {
+			t.Fatal("the report of a ReadOnly datastore is repaired")
+		}
+		checkContents(t, bc, store.contents(4))
+		bc.Close()
+
+		data, err := os.ReadFile(path.Join(store.dir, store.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, damaged) {
+			t.Fatal("the damaged file of a ReadOnly datastore is modified")
+		}
+		if fileExists(path.Join(store.dir, "quarantine")) || fileExists(path.Join(store.dir, "keydir")) {
+			t.Fatalf("got files %v, want the damaged data file only", listFiles(t, store.dir, ""))
+		}
+	}
+}
+
+func TestOpenWithReport(t *testing.T) {
+	store := newDamagedStore(t)
+	damaged := store.data[:store.offsets[9]+1]
+	store.write(t, damaged)
+
+	bc, report, err := OpenWithReport(store.dir, WithReadWrite(), WithReadOnly())
+	if err == nil || !strings.Contains(err.Error(), errInvalidOpt.Error()) || bc != nil || !reflect.DeepEqual(report, RecoveryReport{}) {
+		t.Fatalf("got %v %+v, want %v and an empty report", err, report, errInvalidOpt)
+	}
+
+	bc, report, err = OpenWithReport(store.dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report, bc.RecoveryReport()) {
+		t.Fatalf("got report %+v, RecoveryReport returns %+v", report, bc.RecoveryReport())
+	}
+	if !report.Repaired || report.IsClean() {
+		t.Fatalf("got recovery report %+v, want the repaired torn file", report)
+	}
+	bc.Close()
+
+	bc, report, err = OpenWithReport(store.dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	if !report.IsClean() || !reflect.DeepEqual(report, bc.RecoveryReport()) {
+		t.Fatalf("got recovery report %+v after the repair, want a clean one", report)
+	}
+	checkContents(t, bc, store.contents(9))
+}
+
+func TestRecoverCleanReopen(t *testing.T) {
+	store := newDamagedStore(t)
+
+	bc, report, err := OpenWithReport(store.dir, WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	if !report.IsClean() {
+		t.Fatalf("got recovery report %+v, want a clean one", report)
+	}
+	checkContents(t, bc, store.contents(10))
+}
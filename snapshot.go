@@ -0,0 +1,160 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask/internal/datastore"
+	"github.com/Eslam-Nawara/bitcask/internal/keydir"
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// errSnapshotClosed happens whenever a snapshot is used after it is closed.
+var errSnapshotClosed = errors.New("snapshot is closed")
+
+// Snapshot is a read only view of the datastore frozen at the moment it was taken.
+// The writes done after taking the snapshot are not visible through it,
+// and Merge keeps the data files it references until it is closed.
+// A snapshot is safe for concurrent use, Close waits for the reads in progress.
+type Snapshot struct {
+	bitcask *Bitcask
+	mu      sync.RWMutex
+	keyDir  keydir.KeyDir
+	files   map[string]bool
+	closed  bool
+}
+
+// Snapshot takes a point-in-time read only view of the datastore.
+// The snapshot holds a copy of the keydir, so it costs memory in proportion to the number of keys.
+// The snapshot must be closed when no longer needed to let Merge reclaim the files it references.
+func (bitcask *Bitcask) Snapshot() *Snapshot {
+	bitcask.accessMu.RLock()
+	defer bitcask.accessMu.RUnlock()
+
+	snapshot := &Snapshot{
+		bitcask: bitcask,
+		keyDir:  bitcask.keyDir.Empty(),
+		files:   make(map[string]bool),
+	}
+	bitcask.liveRecs(bitcask.keyDir, iterOptions{}, func(key string, rec recfmt.KeyDirRec) {
+		snapshot.keyDir.Put(key, rec)
+		snapshot.files[rec.FileId] = true
+	})
+
+	for fileId := range snapshot.files {
+		bitcask.retainFile(fileId)
+	}
+
+	return snapshot
+}
+
+// liveRecs calls fn for the records of the given keydir that are not expired, within the bounds
+// and in the order of the given iteration options.
+func (bitcask *Bitcask) liveRecs(keyDir keydir.KeyDir, opts iterOptions, fn func(key string, rec recfmt.KeyDirRec)) {
+	now := time.Now().UnixMicro()
+	visit := func(key string, rec recfmt.KeyDirRec) bool {
+		if !rec.IsExpired(now) {
+			fn(key, rec)
+		}
+		return true
+	}
+
+	switch {
+	case opts.reverse:
+		keyDir.Descend(opts.start, opts.end, visit)
+	case opts.ordered:
+		keyDir.Ascend(opts.start, opts.end, visit)
+	default:
+		keyDir.Range(visit)
+	}
+}
+
+func (snapshot *Snapshot) Get(key string) (string, error) {
+	value, err := snapshot.GetBytes([]byte(key))
+	return string(value), err
+}
+
+// GetBytes is the binary-safe version of Get.
+func (snapshot *Snapshot) GetBytes(key []byte) ([]byte, error) {
+	snapshot.mu.RLock()
+	defer snapshot.mu.RUnlock()
+
+	if snapshot.closed {
+		return nil, fmt.Errorf("Get: %s", errSnapshotClosed)
+	}
+
+	rec, isExist := snapshot.keyDir.Get(string(key))
+	if !isExist {
+		return nil, fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+	}
+
+	return snapshot.bitcask.dataStore.ReadValueFromFile(rec.FileId, key, rec.ValuePos, rec.ValueSize)
+}
+
+func (snapshot *Snapshot) ListKeys() []string {
+	snapshot.mu.RLock()
+	defer snapshot.mu.RUnlock()
+
+	if snapshot.closed {
+		return make([]string, 0)
+	}
+
+	res := make([]string, 0, snapshot.keyDir.Len())
+	snapshot.keyDir.Range(func(key string, _ recfmt.KeyDirRec) bool {
+		res = append(res, key)
+		return true
+	})
+
+	return res
+}
+
+// ListKeysBytes is the binary-safe version of ListKeys.
+func (snapshot *Snapshot) ListKeysBytes() [][]byte {
+	snapshot.mu.RLock()
+	defer snapshot.mu.RUnlock()
+
+	if snapshot.closed {
+		return make([][]byte, 0)
+	}
+
+	res := make([][]byte, 0, snapshot.keyDir.Len())
+	snapshot.keyDir.Range(func(key string, _ recfmt.KeyDirRec) bool {
+		res = append(res, []byte(key))
+		return true
+	})
+
+	return res
+}
+
+func (snapshot *Snapshot) Fold(fn func(string, string, any) any, acc any) any {
+	return snapshot.FoldBytes(func(key, value []byte, acc any) any {
+		return fn(string(key), string(value), acc)
+	}, acc)
+}
+
+// FoldBytes is the binary-safe version of Fold.
+func (snapshot *Snapshot) FoldBytes(fn func([]byte, []byte, any) any, acc any) any {
+	it := snapshot.Iterator()
+	defer it.Close()
+
+	return fold(it, fn, acc)
+}
+
+// Close releases the data files referenced by the snapshot.
+// The data files that has been merged while the snapshot was alive are deleted once no snapshot references them.
+func (snapshot *Snapshot) Close() {
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+
+	if snapshot.closed {
+		return
+	}
+	snapshot.closed = true
+
+	for fileId := range snapshot.files {
+		snapshot.bitcask.releaseFile(fileId)
+	}
+	snapshot.keyDir = nil
+}
@@ -0,0 +1,170 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// checkSnapshot checks that the given snapshot has exactly the given keys and values.
+func checkSnapshot(t *testing.T, snapshot *Snapshot, want map[string]string) {
+	t.Helper()
+
+	if keys := snapshot.ListKeys(); len(keys) != len(want) {
+		t.Fatalf("got %d keys, want %d", len(keys), len(want))
+	}
+	for key, value := range want {
+		got, err := snapshot.Get(key)
+		if err != nil {
+			t.Fatalf("Get(%q): %s", key, err)
+		}
+		if got != value {
+			t.Fatalf("Get(%q): got %q, want %q", key, got, value)
+		}
+	}
+
+	it := snapshot.Iterator()
+	defer it.Close()
+	visited := 0
+	for it.Next() {
+		if want[string(it.Key())] != string(it.Value()) {
+			t.Fatalf("iterated %q: got %q, want %q", it.Key(), it.Value(), want[string(it.Key())])
+		}
+		visited++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if visited != len(want) {
+		t.Fatalf("iterated %d keys, want %d", visited, len(want))
+	}
+}
+
+func TestSnapshotMerge(t *testing.T) {
+	dir := t.TempDir()
+	want := fillStore(t, dir)
+
+	bc := openStore(t, dir, WithMaxFileSize(2048))
+	defer bc.Close()
+	snapshot := bc.Snapshot()
+	files := make([]string, 0)
+	for file := range snapshot.files {
+		files = append(files, file)
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := bc.Put(fmt.Sprintf("key%02d", i), "after the snapshot"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the merged files are kept for the snapshot until it is closed.
+	for _, file := range files {
+		if !fileExists(path.Join(dir, file)) {
+			t.Fatalf("%s referenced by the snapshot is deleted by Merge", file)
+		}
+	}
+	checkSnapshot(t, snapshot, want)
+
+	snapshot.Close()
+	if _, err := snapshot.Get("key01"); err == nil || !strings.Contains(err.Error(), errSnapshotClosed.Error()) {
+		t.Fatalf("Get after Close: got error %v, want %v", err, errSnapshotClosed)
+	}
+	for _, file := range files {
+		if fileExists(path.Join(dir, file)) {
+			t.Fatalf("merged %s is left after the snapshot is closed", file)
+		}
+	}
+	if value, err := bc.Get("key01"); err != nil || value != "after the snapshot" {
+		t.Fatalf("Get: got %q %v, want %q", value, err, "after the snapshot")
+	}
+}
+
+func TestSnapshotConcurrentClose(t *testing.T) {
+	dir := t.TempDir()
+	want := fillStore(t, dir)
+
+	bc := openStore(t, dir)
+	defer bc.Close()
+	snapshot := bc.Snapshot()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key, value := range want {
+				got, err := snapshot.Get(key)
+				if err != nil {
+					if !strings.Contains(err.Error(), errSnapshotClosed.Error()) {
+						t.Errorf("Get(%q): %s", key, err)
+					}
+					return
+				}
+				if got != value {
+					t.Errorf("Get(%q): got %q, want %q", key, got, value)
+					return
+				}
+			}
+		}()
+	}
+	snapshot.Close()
+	wg.Wait()
+
+	if keys := snapshot.ListKeys(); len(keys) != 0 {
+		t.Fatalf("got %d keys from a closed snapshot", len(keys))
+	}
+}
+
+func TestIteratorCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir, WithOrderedKeyDir())
+	defer bc.Close()
+	for i := 0; i < 10; i++ {
+		if err := bc.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bc.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the value of key5 is corrupted on the disk after it is loaded.
+	files := listFiles(t, dir, ".data")
+	file, err := os.OpenFile(path.Join(dir, files[0]), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recLen := int64(recfmt.DataFileHdrSize + len("key0") + len("value0"))
+	_, err = file.WriteAt([]byte{'X'}, 5*recLen+recLen-1)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it := bc.Iterator(IterPrefix([]byte("key")))
+	visited := 0
+	for it.Next() {
+		visited++
+	}
+	if visited != 5 {
+		t.Fatalf("iterated %d keys before the corrupted one, want 5", visited)
+	}
+	if err := it.Err(); err == nil || !strings.Contains(err.Error(), recfmt.ErrDataCorruption.Error()) {
+		t.Fatalf("got error %v, want %v", err, recfmt.ErrDataCorruption)
+	}
+	if it.Next() {
+		t.Fatal("Next succeeded after an error")
+	}
+	if err := it.Close(); err == nil {
+		t.Fatal("Close of a failed iterator returned no error")
+	}
+}
@@ -0,0 +1,168 @@
+package bitcask
+
+import (
+	"github.com/Eslam-Nawara/bitcask/internal/datastore"
+	"github.com/Eslam-Nawara/bitcask/internal/keydir"
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+type (
+	// Stats describes the keys and the disk usage of the whole datastore, it helps deciding when Merge is worth running.
+	Stats struct {
+		// Keys is the number of keys of all the buckets, including the expired keys not yet removed by Merge.
+		Keys int
+		// TompStones is the number of tombstones in the data files.
+		TompStones int
+		// ActiveFile is the name of the data file the writes are appended to, it is empty before the first write.
+		ActiveFile string
+		// FileCount is the number of data files.
+		FileCount int
+		// Files holds the stats of the data files mapped by their names.
+		Files map[string]FileStats
+	}
+
+	// FileStats describes the disk usage of a data file.
+	FileStats struct {
+		// TotalBytes is the size of the file.
+		TotalBytes int64
+		// LiveBytes is the size of the records of the file that are still pointed to by the keys,
+		// the rest of the file is reclaimed by Merge.
+		LiveBytes int64
+	}
+
+	// fileStats holds the counters of a data file maintained on every write.
+	fileStats struct {
+		total      int64
+		live       int64
+		tompStones int
+	}
+)
+
+// Stats returns the stats of the datastore.
+// The stats are maintained on every write, so calling Stats does not scan the keys or the files.
+func (bitcask *Bitcask) Stats() Stats {
+	bitcask.accessMu.RLock()
+	defer bitcask.accessMu.RUnlock()
+
+	stats := Stats{
+		FileCount: len(bitcask.fileStats),
+		Files:     make(map[string]FileStats, len(bitcask.fileStats)),
+	}
+	if bitcask.activeFile != nil {
+		stats.ActiveFile = bitcask.activeFile.Name()
+	}
+	for _, bucket := range bitcask.buckets {
+		stats.Keys += bucket.keyDir.Len()
+	}
+	for name, file := range bitcask.fileStats {
+		stats.TompStones += file.tompStones
+		stats.Files[name] = FileStats{TotalBytes: file.total, LiveBytes: file.live}
+	}
+
+	return stats
+}
+
+// TotalBytes returns the size of all the data files.
+func (stats Stats) TotalBytes() int64 {
+	var total int64
+	for _, file := range stats.Files {
+		total += file.TotalBytes
+	}
+
+	return total
+}
+
+// DeadBytes returns the size of the records that Merge would reclaim.
+func (stats Stats) DeadBytes() int64 {
+	var dead int64
+	for _, file := range stats.Files {
+		dead += file.TotalBytes - file.LiveBytes
+	}
+
+	return dead
+}
+
+// Fragmentation returns the ratio of the dead bytes to the size of all the data files, between zero and one.
+func (stats Stats) Fragmentation() float64 {
+	total := stats.TotalBytes()
+	if total == 0 {
+		return 0
+	}
+
+	return float64(stats.DeadBytes()) / float64(total)
+}
+
+// loadStats sets up the counters of the given data files, then counts the live bytes of the loaded buckets.
+// It also stamps the next writes after the largest timestamp of the files, in case the clock went back since they were written.
+func (bitcask *Bitcask) loadStats(files map[string]keydir.FileStats) {
+	for name, file := range files {
+		bitcask.fileStats[name] = &fileStats{total: file.Size, tompStones: file.TompStones}
+		if file.MaxTStamp > bitcask.lastTStamp {
+			bitcask.lastTStamp = file.MaxTStamp
+		}
+	}
+
+	for _, bucket := range bitcask.buckets {
+		for _, keyDir := range []keydir.KeyDir{bucket.keyDir, bucket.reservedKeyDir} {
+			keyDir.Range(func(key string, rec recfmt.KeyDirRec) bool {
+				bitcask.statsOf(rec.FileId).live += recSize(key, rec)
+				return true
+			})
+		}
+	}
+}
+
+// putRec points the given key of the given keydir to rec and moves its live bytes from its old record.
+// the caller must hold the access lock for writing.
+func (bitcask *Bitcask) putRec(keyDir keydir.KeyDir, key string, rec recfmt.KeyDirRec) {
+	bitcask.deleteRec(keyDir, key)
+	keyDir.Put(key, rec)
+	bitcask.statsOf(rec.FileId).live += recSize(key, rec)
+}
+
+// deleteRec removes the given key from the given keydir and its live bytes.
+// the caller must hold the access lock for writing.
+func (bitcask *Bitcask) deleteRec(keyDir keydir.KeyDir, key string) {
+	old, ok := keyDir.Get(key)
+	if !ok {
+		return
+	}
+
+	keyDir.Delete(key)
+	bitcask.statsOf(old.FileId).live -= recSize(key, old)
+}
+
+// dropLive removes the live bytes of all the records of the given keydirs.
+// the caller must hold the access lock for writing.
+func (bitcask *Bitcask) dropLive(keyDirs ...keydir.KeyDir) {
+	for _, keyDir := range keyDirs {
+		keyDir.Range(func(key string, rec recfmt.KeyDirRec) bool {
+			bitcask.statsOf(rec.FileId).live -= recSize(key, rec)
+			return true
+		})
+	}
+}
+
+// appended updates the size of the given append file after a write of the given number of tombstones.
+// the caller must hold the access lock for writing.
+func (bitcask *Bitcask) appended(file *datastore.AppendFile, tompStones int) {
+	stats := bitcask.statsOf(file.Name())
+	stats.total = file.Size()
+	stats.tompStones += tompStones
+}
+
+// statsOf returns the counters of the given data file, creating them if not exist.
+func (bitcask *Bitcask) statsOf(fileId string) *fileStats {
+	stats, ok := bitcask.fileStats[fileId]
+	if !ok {
+		stats = &fileStats{}
+		bitcask.fileStats[fileId] = stats
+	}
+
+	return stats
+}
+
+// recSize returns the size of the data file record of the given key and keydir record.
+func recSize(key string, rec recfmt.KeyDirRec) int64 {
+	return int64(recfmt.DataFileHdrSize+len(key)) + int64(rec.ValueSize)
+}
@@ -0,0 +1,106 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+// checkFileStats checks that the stats of every data file match its size on the disk,
+// and that none has more live bytes than its size.
+func checkFileStats(t *testing.T, dir string, stats Stats) {
+	t.Helper()
+
+	if stats.FileCount != len(stats.Files) || len(stats.Files) != len(listFiles(t, dir, ".data")) {
+		t.Fatalf("got stats of %d files, want %d", len(stats.Files), len(listFiles(t, dir, ".data")))
+	}
+	for name, file := range stats.Files {
+		info, err := os.Stat(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.TotalBytes != info.Size() {
+			t.Fatalf("%s: got TotalBytes %d, want its size %d", name, file.TotalBytes, info.Size())
+		}
+		if file.LiveBytes < 0 || file.LiveBytes > file.TotalBytes {
+			t.Fatalf("%s: got LiveBytes %d out of %d bytes", name, file.LiveBytes, file.TotalBytes)
+		}
+	}
+}
+
+// checkStatsEqual checks that the stats of the keys and of every data file are the same.
+func checkStatsEqual(t *testing.T, got, want Stats) {
+	t.Helper()
+
+	if got.Keys != want.Keys || got.TompStones != want.TompStones {
+		t.Fatalf("got %d keys and %d tombstones, want %d and %d", got.Keys, got.TompStones, want.Keys, want.TompStones)
+	}
+	if len(got.Files) != len(want.Files) {
+		t.Fatalf("got stats of %d files, want %d", len(got.Files), len(want.Files))
+	}
+	for name, file := range want.Files {
+		if got.Files[name] != file {
+			t.Fatalf("%s: got %+v, want %+v", name, got.Files[name], file)
+		}
+	}
+}
+
+func TestStatsReopen(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir, WithMaxFileSize(512))
+	for i := 0; i < 20; i++ {
+		if err := bc.Put(fmt.Sprintf("key%02d", i), fmt.Sprintf("value%02d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if err := bc.Delete(fmt.Sprintf("key%02d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch := NewBatch()
+	batch.Put("key05", "batch value")
+	batch.Delete("key06")
+	batch.Put("batch", "value")
+	if err := bc.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := bc.Stats()
+	if stats.Keys != 15 || stats.TompStones != 6 {
+		t.Fatalf("got %d keys and %d tombstones, want 15 and 6", stats.Keys, stats.TompStones)
+	}
+	if stats.DeadBytes() == 0 {
+		t.Fatal("got no dead bytes after overwrites and deletes")
+	}
+	checkFileStats(t, dir, stats)
+	bc.Close()
+
+	bc = openStore(t, dir, WithMaxFileSize(512))
+	checkStatsEqual(t, bc.Stats(), stats)
+
+	deadBytes := stats.DeadBytes()
+
+	// the records written before the reopen are in files other than the active one, so Merge rewrites them.
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("after-merge", "value"); err != nil {
+		t.Fatal(err)
+	}
+	stats = bc.Stats()
+	if stats.Keys != 16 {
+		t.Fatalf("got %d keys after Merge, want 16", stats.Keys)
+	}
+	if stats.DeadBytes() >= deadBytes {
+		t.Fatalf("got %d dead bytes after Merge, want less than %d", stats.DeadBytes(), deadBytes)
+	}
+	checkFileStats(t, dir, stats)
+	bc.Close()
+
+	bc = openStore(t, dir, WithMaxFileSize(512))
+	defer bc.Close()
+	checkStatsEqual(t, bc.Stats(), stats)
+	checkFileStats(t, dir, bc.Stats())
+}
@@ -0,0 +1,165 @@
+package bitcask
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask/internal/datastore"
+	"github.com/Eslam-Nawara/bitcask/internal/recfmt"
+)
+
+// valueReader streams a value of the datastore and keeps its data file from being deleted by Merge until closed.
+type valueReader struct {
+	io.ReadCloser
+	bitcask *Bitcask
+	fileId  string
+	closed  bool
+}
+
+// GetReader returns a reader streaming the value of the given key directly from its data file,
+// so the value is never held in memory as a whole.
+// The record checksum is validated once the whole value is read, a corrupted value fails the last read.
+// An encrypted value is authenticated as a whole, so it is read and decrypted in memory before the reader is returned,
+// and GetReader refuses the encrypted values larger than 64 MiB, which Get still reads.
+// The reader must be closed when no longer needed.
+func (bitcask *Bitcask) GetReader(key string) (io.ReadCloser, error) {
+	return bitcask.GetReaderBytes([]byte(key))
+}
+
+// GetReaderBytes is the binary-safe version of GetReader.
+func (bitcask *Bitcask) GetReaderBytes(key []byte) (io.ReadCloser, error) {
+	bitcask.accessMu.RLock()
+	defer bitcask.accessMu.RUnlock()
+
+	rec, isExist := bitcask.keyDir.Get(string(key))
+	if !isExist || rec.IsExpired(time.Now().UnixMicro()) {
+		return nil, fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+	}
+
+	r, err := bitcask.dataStore.OpenValue(rec.FileId, key, rec.ValuePos, rec.ValueSize)
+	if err != nil {
+		return nil, err
+	}
+	bitcask.retainFile(rec.FileId)
+
+	return &valueReader{ReadCloser: r, bitcask: bitcask, fileId: rec.FileId}, nil
+}
+
+// PutReader stores the given key with a value of the given size read from r.
+// The value is read before the datastore is locked, so a slow reader stalls neither the reads nor the writes.
+// A value of up to 1 MiB is read in memory. A larger value is spooled to a temporary file then streamed
+// to the active file without being held in memory, which costs an extra write and read of the value,
+// and it is compressed while spooled if the compression is enabled.
+// The values that can not be streamed are read in memory as a whole, and PutReader refuses them above 64 MiB,
+// which Put still writes: the encrypted values, as they are authenticated as a whole and must not be spooled
+// unencrypted to the disk, and the values of the buckets having indexes, whose terms are extracted from the whole value.
+// Exactly size bytes are read from r, nothing is written if r fails to provide them.
+func (bitcask *Bitcask) PutReader(key string, r io.Reader, size int64) error {
+	return bitcask.PutReaderBytes([]byte(key), r, size)
+}
+
+// PutReaderBytes is the binary-safe version of PutReader.
+func (bitcask *Bitcask) PutReaderBytes(key []byte, r io.Reader, size int64) error {
+	if bitcask.usrOpts.accessPermission == ReadOnly {
+		return fmt.Errorf("Put: %s", errRequireWrite)
+	}
+	err := checkKey(key)
+	if err != nil {
+		return err
+	}
+	if size < 0 || size > recfmt.MaxValueSize {
+		return fmt.Errorf("%d bytes: %s", size, datastore.ErrValueTooLarge)
+	}
+
+	if size <= datastore.SpoolMemSize || bitcask.encrypter != nil || bitcask.isIndexed() {
+		return bitcask.putBuffered(key, r, size)
+	}
+
+	spooled, err := bitcask.dataStore.SpoolValue(r, size)
+	if err != nil {
+		return err
+	}
+	defer spooled.Release()
+
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
+
+	if bitcask.dropped {
+		return fmt.Errorf("%s: %s", bitcask.name, errBucketNotExist)
+	}
+	err = bitcask.checkIndexes()
+	if err != nil {
+		return err
+	}
+	if len(bitcask.indexes) > 0 {
+		// an index is created while the value is spooled.
+		value, err := readValue(spooled.Decoded(), size)
+		if err != nil {
+			return err
+		}
+		return bitcask.put(key, value, 0)
+	}
+
+	tStamp := bitcask.nextTStamp()
+	rec, err := bitcask.activeFile.WriteDataFrom(bitcask.id, key, spooled, spooled.Size, spooled.Flags, tStamp, 0)
+	if err != nil {
+		return err
+	}
+
+	bitcask.putRec(bitcask.keyDir, string(key), rec)
+	bitcask.appended(bitcask.activeFile, 0)
+	bitcask.notify([]batchOp{{key: key}}, tStamp)
+
+	return nil
+}
+
+// putBuffered reads the value of the given size from r in memory before locking the datastore, then stores it.
+func (bitcask *Bitcask) putBuffered(key []byte, r io.Reader, size int64) error {
+	value, err := readValue(r, size)
+	if err != nil {
+		return err
+	}
+
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
+
+	return bitcask.put(key, value, 0)
+}
+
+// readValue reads a value of the given size from r in memory, it refuses the values larger than
+// datastore.MaxBufferedValue.
+func readValue(r io.Reader, size int64) ([]byte, error) {
+	if size > datastore.MaxBufferedValue {
+		return nil, fmt.Errorf("%d bytes: %s", size, datastore.ErrNotStreamable)
+	}
+
+	value := make([]byte, size)
+	_, err := io.ReadFull(r, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// isIndexed reports whether the bucket has any indexes.
+func (bitcask *Bitcask) isIndexed() bool {
+	bitcask.accessMu.RLock()
+	defer bitcask.accessMu.RUnlock()
+
+	return len(bitcask.indexes) > 0
+}
+
+// Close closes the reader and releases its data file.
+func (reader *valueReader) Close() error {
+	if reader.closed {
+		return nil
+	}
+	reader.closed = true
+
+	err := reader.ReadCloser.Close()
+	reader.bitcask.releaseFile(reader.fileId)
+
+	return err
+}
@@ -0,0 +1,204 @@
+package bitcask
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask/internal/datastore"
+)
+
+// blockingReader blocks its first read until unblocked.
+type blockingReader struct {
+	io.Reader
+	unblock chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	<-r.unblock
+	return r.Reader.Read(p)
+}
+
+func TestPutReaderSlowReaderDoesNotBlock(t *testing.T) {
+	for _, size := range []int{10, 2 << 20} {
+		dir := t.TempDir()
+		bc, err := Open(dir, WithReadWrite())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.Put("other", "value"); err != nil {
+			t.Fatal(err)
+		}
+
+		value := strings.Repeat("v", size)
+		r := &blockingReader{Reader: strings.NewReader(value), unblock: make(chan struct{})}
+		putErr := make(chan error)
+		go func() {
+			putErr <- bc.PutReader("key", r, int64(size))
+		}()
+
+		got := make(chan error)
+		go func() {
+			if _, err := bc.Get("other"); err != nil {
+				got <- err
+				return
+			}
+			got <- bc.Put("another", "value")
+		}()
+		select {
+		case err := <-got:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d bytes: Get and Put are blocked by the reader of PutReader", size)
+		}
+
+		close(r.unblock)
+		if err := <-putErr; err != nil {
+			t.Fatal(err)
+		}
+		reader, err := bc.GetReader("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(stored, []byte(value)) {
+			t.Fatalf("%d bytes: got a value of %d bytes", size, len(stored))
+		}
+		bc.Close()
+
+		spooled, _ := filepath.Glob(filepath.Join(dir, "*.spool"))
+		if len(spooled) != 0 {
+			t.Fatalf("%d bytes: spooled files are left: %v", size, spooled)
+		}
+	}
+}
+
+func TestPutReaderShortReader(t *testing.T) {
+	bc, err := Open(t.TempDir(), WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	for _, size := range []int64{10, 2 << 20} {
+		err := bc.PutReader("key", strings.NewReader("short"), size)
+		if err == nil {
+			t.Fatalf("%d bytes: PutReader of a short reader succeeded", size)
+		}
+		if _, err := bc.Get("key"); err == nil {
+			t.Fatalf("%d bytes: the key of a failed PutReader exists", size)
+		}
+	}
+}
+
+// readStream reads the value of the given key through GetReader.
+func readStream(t *testing.T, bc *Bitcask, key string) []byte {
+	t.Helper()
+
+	reader, err := bc.GetReader(key)
+	if err != nil {
+		t.Fatalf("GetReader(%q): %s", key, err)
+	}
+	defer reader.Close()
+	value, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("GetReader(%q): %s", key, err)
+	}
+
+	return value
+}
+
+func TestPutReaderCompression(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir, WithCompression(1024))
+	defer bc.Close()
+
+	compressible := bytes.Repeat([]byte("compressible "), 200<<10)
+	random := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(random)
+	for key, value := range map[string][]byte{"compressible": compressible, "random": random} {
+		before := bc.Stats().TotalBytes()
+		if err := bc.PutReader(key, bytes.NewReader(value), int64(len(value))); err != nil {
+			t.Fatal(err)
+		}
+		written := bc.Stats().TotalBytes() - before
+
+		// the compressible value is spooled compressed, while the random one is kept as is.
+		if key == "compressible" && written >= int64(len(value))/10 {
+			t.Fatalf("%s: wrote %d bytes for a value of %d bytes", key, written, len(value))
+		}
+		if key == "random" && written < int64(len(value)) {
+			t.Fatalf("%s: wrote %d bytes for a value of %d bytes", key, written, len(value))
+		}
+		if !bytes.Equal(readStream(t, bc, key), value) {
+			t.Fatalf("%s: GetReader returned a different value", key)
+		}
+		got, err := bc.GetBytes([]byte(key))
+		if err != nil || !bytes.Equal(got, value) {
+			t.Fatalf("%s: GetBytes returned a different value: %v", key, err)
+		}
+	}
+
+	if spooled, _ := filepath.Glob(filepath.Join(dir, "*.spool")); len(spooled) != 0 {
+		t.Fatalf("spooled files are left: %v", spooled)
+	}
+}
+
+func TestPutReaderEncryption(t *testing.T) {
+	dir := t.TempDir()
+	ring, err := NewKeyRing(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := openStore(t, dir, WithEncryption(ring))
+	defer bc.Close()
+
+	value := bytes.Repeat([]byte("secret "), 300<<10)
+	if err := bc.PutReader("key", bytes.NewReader(value), int64(len(value))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readStream(t, bc, "key"), value) {
+		t.Fatal("GetReader returned a different value")
+	}
+	checkNotInFiles(t, dir, "secret secret")
+
+	// the encrypted values are read in memory as a whole, so the larger ones are refused before reading them.
+	err = bc.PutReader("large", strings.NewReader(""), datastore.MaxBufferedValue+1)
+	if err == nil || !strings.Contains(err.Error(), datastore.ErrNotStreamable.Error()) {
+		t.Fatalf("got error %v, want %v", err, datastore.ErrNotStreamable)
+	}
+}
+
+func TestPutReaderIndexedBucket(t *testing.T) {
+	bc := openStore(t, t.TempDir())
+	defer bc.Close()
+	prefixExtractor := func(_, value []byte) [][]byte {
+		return [][]byte{value[:10]}
+	}
+	if err := bc.CreateIndex("value", prefixExtractor); err != nil {
+		t.Fatal(err)
+	}
+
+	value := "indexed:" + strings.Repeat("v", 2<<20)
+	if err := bc.PutReader("key", strings.NewReader(value), int64(len(value))); err != nil {
+		t.Fatal(err)
+	}
+	if keys := lookup(t, bc, value[:10]); len(keys) != 1 || keys[0] != "key" {
+		t.Fatalf("got keys %v for the value written by PutReader, want [key]", keys)
+	}
+
+	err := bc.PutReader("large", strings.NewReader(""), datastore.MaxBufferedValue+1)
+	if err == nil || !strings.Contains(err.Error(), datastore.ErrNotStreamable.Error()) {
+		t.Fatalf("got error %v, want %v", err, datastore.ErrNotStreamable)
+	}
+}
@@ -0,0 +1,62 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask/internal/datastore"
+)
+
+// NoTTL is returned by TTL for the keys that never expire.
+const NoTTL time.Duration = -1
+
+// errInvalidTTL happens when a key is stored with a non positive time to live.
+var errInvalidTTL = errors.New("time to live must be positive")
+
+// PutWithTTL stores a key and a value that expires after the given time to live.
+// Once expired, the key is treated as if it does not exist and its data is removed by Merge.
+func (bitcask *Bitcask) PutWithTTL(key, value string, ttl time.Duration) error {
+	return bitcask.PutBytesWithTTL([]byte(key), []byte(value), ttl)
+}
+
+// PutBytesWithTTL is the binary-safe version of PutWithTTL.
+func (bitcask *Bitcask) PutBytesWithTTL(key, value []byte, ttl time.Duration) error {
+	if bitcask.usrOpts.accessPermission == ReadOnly {
+		return fmt.Errorf("Put: %s", errRequireWrite)
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("%s: %s", ttl, errInvalidTTL)
+	}
+	err := checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
+
+	return bitcask.put(key, value, time.Now().Add(ttl).UnixMicro())
+}
+
+// TTL returns the remaining time to live of the given key, or NoTTL if the key never expires.
+func (bitcask *Bitcask) TTL(key string) (time.Duration, error) {
+	return bitcask.TTLBytes([]byte(key))
+}
+
+// TTLBytes is the binary-safe version of TTL.
+func (bitcask *Bitcask) TTLBytes(key []byte) (time.Duration, error) {
+	bitcask.accessMu.RLock()
+	defer bitcask.accessMu.RUnlock()
+
+	if !bitcask.exists(key) {
+		return 0, fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+	}
+
+	rec, _ := bitcask.keyDir.Get(string(key))
+	if rec.Expiry == 0 {
+		return NoTTL, nil
+	}
+
+	return time.Until(time.UnixMicro(rec.Expiry)), nil
+}
@@ -0,0 +1,176 @@
+package bitcask
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eslam-Nawara/bitcask/internal/datastore"
+)
+
+// ttl is the time to live of the keys expired by the tests.
+const ttl = 50 * time.Millisecond
+
+// checkExpired checks that the given key reads as if it does not exist.
+func checkExpired(t *testing.T, bc *Bitcask, key string) {
+	t.Helper()
+
+	if _, err := bc.Get(key); err == nil || !strings.Contains(err.Error(), datastore.ErrKeyNotExist.Error()) {
+		t.Fatalf("Get(%q): got error %v, want %v", key, err, datastore.ErrKeyNotExist)
+	}
+	if _, err := bc.TTL(key); err == nil || !strings.Contains(err.Error(), datastore.ErrKeyNotExist.Error()) {
+		t.Fatalf("TTL(%q): got error %v, want %v", key, err, datastore.ErrKeyNotExist)
+	}
+}
+
+// checkNotInFiles checks that none of the data and hint files of the given datastore holds the given value.
+func checkNotInFiles(t *testing.T, dir, value string) {
+	t.Helper()
+
+	for _, name := range append(listFiles(t, dir, ".data"), listFiles(t, dir, ".hint")...) {
+		data, err := os.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte(value)) {
+			t.Fatalf("%s holds %q", name, value)
+		}
+	}
+}
+
+func TestTTLExpiredKey(t *testing.T) {
+	bc := openStore(t, t.TempDir())
+	defer bc.Close()
+
+	if err := bc.PutWithTTL("a", "1", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	left, err := bc.TTL("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left <= 0 || left > ttl {
+		t.Fatalf("got TTL %s, want within (0, %s]", left, ttl)
+	}
+	if left, err := bc.TTL("b"); err != nil || left != NoTTL {
+		t.Fatalf("TTL of a key without expiry: got %s %v, want NoTTL", left, err)
+	}
+	if err := bc.PutWithTTL("c", "3", 0); err == nil {
+		t.Fatal("PutWithTTL with a zero time to live succeeded")
+	}
+
+	time.Sleep(2 * ttl)
+	checkExpired(t, bc, "a")
+	for _, key := range bc.ListKeys() {
+		if key == "a" {
+			t.Fatal("an expired key is listed")
+		}
+	}
+}
+
+func TestTTLOverExistingKey(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir)
+	if err := bc.Put("a", "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.PutWithTTL("a", "new", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := bc.Get("a"); err != nil || value != "new" {
+		t.Fatalf("Get: got %q %v, want %q", value, err, "new")
+	}
+	bc.Close()
+
+	time.Sleep(2 * ttl)
+
+	// the expired record still shadows the old value of its key.
+	bc = openStore(t, dir)
+	checkExpired(t, bc, "a")
+	bc.Close()
+
+	bc, err := Open(dir, WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+	checkExpired(t, bc, "a")
+}
+
+func TestTTLMerge(t *testing.T) {
+	dir := t.TempDir()
+	bc := openStore(t, dir)
+	if err := bc.Put("a", "old value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.PutWithTTL("a", "expiring value", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put("b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	bc.Close()
+
+	time.Sleep(2 * ttl)
+
+	// the records are in a file other than the active one after reopening, so Merge rewrites them.
+	bc = openStore(t, dir)
+	if err := bc.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	checkExpired(t, bc, "a")
+	checkContents(t, bc, map[string]string{"b": "2"})
+	bc.Close()
+
+	checkNotInFiles(t, dir, "expiring value")
+	checkNotInFiles(t, dir, "old value")
+
+	bc = openStore(t, dir)
+	defer bc.Close()
+	checkExpired(t, bc, "a")
+	checkContents(t, bc, map[string]string{"b": "2"})
+}
+
+func TestTTLIncr(t *testing.T) {
+	bc := openStore(t, t.TempDir())
+	defer bc.Close()
+
+	if err := bc.PutWithTTL("counter", "10", ttl); err != nil {
+		t.Fatal(err)
+	}
+	counter, err := bc.Incr("counter", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter != 15 {
+		t.Fatalf("got counter %d, want 15", counter)
+	}
+	left, err := bc.TTL("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left == NoTTL || left > ttl {
+		t.Fatalf("got TTL %s after Incr, want the expiry kept", left)
+	}
+
+	time.Sleep(2 * ttl)
+	checkExpired(t, bc, "counter")
+
+	// an expired counter starts again from zero, without expiry.
+	counter, err = bc.Incr("counter", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter != 1 {
+		t.Fatalf("got counter %d after expiry, want 1", counter)
+	}
+	if left, err := bc.TTL("counter"); err != nil || left != NoTTL {
+		t.Fatalf("TTL of a counter recreated after expiry: got %s %v, want NoTTL", left, err)
+	}
+}
@@ -0,0 +1,169 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Eslam-Nawara/bitcask/internal/datastore"
+)
+
+var (
+	// ErrConflict happens when a transaction commits after any of the keys it read has changed.
+	ErrConflict = errors.New("transaction conflict: a read key has been changed")
+
+	// errTxnReadOnly happens whenever a write is done through a transaction started by View.
+	errTxnReadOnly = errors.New("transaction is read only")
+	// errTxnDone happens whenever a transaction is used after its callback returns.
+	errTxnDone = errors.New("transaction is already done")
+)
+
+// Txn represents an optimistic transaction over the datastore.
+// Reads go directly to the datastore while writes are buffered until the transaction commits.
+// Txn records the timestamp of every key it reads, and the commit fails with ErrConflict
+// if any of them has changed in the meantime.
+type Txn struct {
+	bitcask  *Bitcask
+	writable bool
+	done     bool
+	reads    map[string]int64
+	writes   *Batch
+}
+
+// Update runs fn in a read-write transaction and commits its writes atomically when fn returns nil.
+// Returns ErrConflict if any key read by the transaction was changed before the commit,
+// in that case nothing is written and fn can be retried.
+func (bitcask *Bitcask) Update(fn func(tx *Txn) error) error {
+	if bitcask.usrOpts.accessPermission == ReadOnly {
+		return fmt.Errorf("Update: %s", errRequireWrite)
+	}
+
+	tx := bitcask.newTxn(true)
+	defer func() { tx.done = true }()
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.commit()
+}
+
+// View runs fn in a read only transaction.
+func (bitcask *Bitcask) View(fn func(tx *Txn) error) error {
+	tx := bitcask.newTxn(false)
+	defer func() { tx.done = true }()
+
+	return fn(tx)
+}
+
+func (tx *Txn) Get(key string) (string, error) {
+	value, err := tx.GetBytes([]byte(key))
+	return string(value), err
+}
+
+// GetBytes is the binary-safe version of Get.
+// It sees the writes done earlier in the same transaction.
+func (tx *Txn) GetBytes(key []byte) ([]byte, error) {
+	if tx.done {
+		return nil, fmt.Errorf("Get: %s", errTxnDone)
+	}
+
+	if op, ok := tx.writes.lookup(key); ok {
+		if op.isDelete {
+			return nil, fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+		}
+		return append([]byte{}, op.value...), nil
+	}
+
+	tx.bitcask.accessMu.RLock()
+	defer tx.bitcask.accessMu.RUnlock()
+
+	value, tStamp, err := tx.bitcask.get(key)
+	if _, ok := tx.reads[string(key)]; !ok {
+		tx.reads[string(key)] = tStamp
+	}
+
+	return value, err
+}
+
+func (tx *Txn) Put(key, value string) error {
+	return tx.PutBytes([]byte(key), []byte(value))
+}
+
+// PutBytes is the binary-safe version of Put.
+func (tx *Txn) PutBytes(key, value []byte) error {
+	err := tx.checkWritable("Put")
+	if err != nil {
+		return err
+	}
+
+	err = checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	tx.writes.PutBytes(key, value)
+	return nil
+}
+
+func (tx *Txn) Delete(key string) error {
+	return tx.DeleteBytes([]byte(key))
+}
+
+// DeleteBytes is the binary-safe version of Delete.
+func (tx *Txn) DeleteBytes(key []byte) error {
+	err := tx.checkWritable("Delete")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.GetBytes(key)
+	if err != nil {
+		return err
+	}
+
+	tx.writes.DeleteBytes(key)
+	return nil
+}
+
+func (bitcask *Bitcask) newTxn(writable bool) *Txn {
+	return &Txn{
+		bitcask:  bitcask,
+		writable: writable,
+		reads:    make(map[string]int64),
+		writes:   NewBatch(),
+	}
+}
+
+// checkWritable returns an error if the transaction can not do the given write operation.
+func (tx *Txn) checkWritable(op string) error {
+	if tx.done {
+		return fmt.Errorf("%s: %s", op, errTxnDone)
+	}
+	if !tx.writable {
+		return fmt.Errorf("%s: %s", op, errTxnReadOnly)
+	}
+
+	return nil
+}
+
+// commit validates that none of the read keys has changed then writes the buffered writes atomically.
+// The access lock is held only during the validation and the write.
+func (tx *Txn) commit() error {
+	bitcask := tx.bitcask
+
+	bitcask.accessMu.Lock()
+	defer bitcask.accessMu.Unlock()
+
+	for key, tStamp := range tx.reads {
+		if rec, _ := bitcask.keyDir.Get(key); rec.TStamp != tStamp {
+			return fmt.Errorf("%s: %w", key, ErrConflict)
+		}
+	}
+
+	if tx.writes.Len() == 0 {
+		return nil
+	}
+
+	return bitcask.write(tx.writes.ops)
+}
@@ -0,0 +1,106 @@
+package bitcask
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTxnConflictOnChangedKey(t *testing.T) {
+	bc := openStore(t, t.TempDir())
+	defer bc.Close()
+	if err := bc.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := bc.Update(func(tx *Txn) error {
+		value, err := tx.Get("a")
+		if err != nil {
+			return err
+		}
+		if err := bc.Put("a", "changed"); err != nil {
+			t.Fatal(err)
+		}
+		return tx.Put("b", value)
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("got error %v, want %v", err, ErrConflict)
+	}
+	checkContents(t, bc, map[string]string{"a": "changed"})
+}
+
+func TestTxnConflictOnCreatedKey(t *testing.T) {
+	bc := openStore(t, t.TempDir())
+	defer bc.Close()
+
+	err := bc.Update(func(tx *Txn) error {
+		if _, err := tx.Get("a"); err == nil {
+			t.Fatal("Get of a missing key succeeded")
+		}
+		if err := bc.Put("a", "created"); err != nil {
+			t.Fatal(err)
+		}
+		return tx.Put("a", "from the transaction")
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("got error %v, want %v", err, ErrConflict)
+	}
+	checkContents(t, bc, map[string]string{"a": "created"})
+}
+
+func TestTxnCommit(t *testing.T) {
+	bc := openStore(t, t.TempDir())
+	defer bc.Close()
+	if err := bc.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := bc.Update(func(tx *Txn) error {
+		value, err := tx.Get("a")
+		if err != nil {
+			return err
+		}
+		if err := tx.Put("b", value); err != nil {
+			return err
+		}
+		// the transaction sees its own writes.
+		if value, err := tx.Get("b"); err != nil || value != "1" {
+			t.Fatalf("Get of a key written in the transaction: got %q %v", value, err)
+		}
+		return tx.Delete("a")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContents(t, bc, map[string]string{"b": "1"})
+}
+
+func TestViewWrite(t *testing.T) {
+	bc := openStore(t, t.TempDir())
+	defer bc.Close()
+	if err := bc.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var txn *Txn
+	err := bc.View(func(tx *Txn) error {
+		txn = tx
+		if value, err := tx.Get("a"); err != nil || value != "1" {
+			t.Fatalf("Get in View: got %q %v", value, err)
+		}
+		if err := tx.Put("b", "2"); err == nil || !strings.Contains(err.Error(), errTxnReadOnly.Error()) {
+			t.Fatalf("Put in View: got error %v, want %v", err, errTxnReadOnly)
+		}
+		if err := tx.Delete("a"); err == nil || !strings.Contains(err.Error(), errTxnReadOnly.Error()) {
+			t.Fatalf("Delete in View: got error %v, want %v", err, errTxnReadOnly)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.Get("a"); err == nil || !strings.Contains(err.Error(), errTxnDone.Error()) {
+		t.Fatalf("Get after View: got error %v, want %v", err, errTxnDone)
+	}
+	checkContents(t, bc, map[string]string{"a": "1"})
+}
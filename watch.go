@@ -0,0 +1,182 @@
+package bitcask
+
+import (
+	"strings"
+	"sync"
+)
+
+// defaultWatchBuffer is the number of events buffered for a subscription unless WatchBuffer is given.
+const defaultWatchBuffer = 128
+
+const (
+	// EventPut is the type of the events emitted for the written keys.
+	EventPut EventType = iota
+	// EventDelete is the type of the events emitted for the deleted keys.
+	EventDelete
+)
+
+type (
+	// EventType is the type of the write an Event is emitted for.
+	EventType int
+
+	// Event describes a single write applied to the datastore.
+	// The Key and Value of an event are shared by all the subscriptions and must not be modified.
+	// The Value is empty for the deletes and the values streamed by PutReader.
+	Event struct {
+		Type  EventType
+		Key   []byte
+		Value []byte
+		// TStamp is the timestamp of the write in unix microseconds,
+		// the writes done together in a batch share the same timestamp.
+		TStamp int64
+		// Dropped is the number of events dropped from the subscription before this event
+		// because its buffer was full.
+		Dropped uint64
+	}
+
+	// WatchOption configures a subscription created by Watch.
+	WatchOption func(*watchOptions)
+
+	// watchOptions groups the options passed to Watch.
+	watchOptions struct {
+		buffer int
+		block  bool
+	}
+
+	// watcher represents a single subscription.
+	watcher struct {
+		prefix  string
+		block   bool
+		events  chan Event
+		done    chan struct{}
+		once    sync.Once
+		dropped uint64
+	}
+)
+
+// WatchBuffer sets the number of events buffered for the subscription, a negative size is taken as zero.
+func WatchBuffer(size int) WatchOption {
+	return func(opts *watchOptions) {
+		if size < 0 {
+			size = 0
+		}
+		opts.buffer = size
+	}
+}
+
+// WatchBlock makes the writers wait for the subscription to receive its events when its buffer is full,
+// instead of dropping the events.
+// All the writes of the datastore are stalled while waiting, so the receiver of the events
+// must not use the datastore until it drains the buffer.
+func WatchBlock() WatchOption {
+	return func(opts *watchOptions) {
+		opts.block = true
+	}
+}
+
+// Watch subscribes to the writes of the keys starting with the given prefix.
+// An event is emitted after every put and delete is appended to the active file,
+// including the writes of batches and transactions, in the order of the writes.
+// By default the events that do not fit in the subscription buffer are dropped and counted
+// in the Dropped field of the next delivered event, WatchBlock makes the writers wait instead.
+// The returned cancel function ends the subscription and closes the channel,
+// the channel is also closed when the datastore is closed.
+func (bitcask *Bitcask) Watch(prefix string, opts ...WatchOption) (<-chan Event, func()) {
+	watchOpts := watchOptions{buffer: defaultWatchBuffer}
+	for _, opt := range opts {
+		opt(&watchOpts)
+	}
+
+	w := &watcher{
+		prefix: prefix,
+		block:  watchOpts.block,
+		events: make(chan Event, watchOpts.buffer),
+		done:   make(chan struct{}),
+	}
+
+	bitcask.watchMu.Lock()
+	bitcask.watchers[w] = true
+	bitcask.watchMu.Unlock()
+
+	return w.events, func() { bitcask.cancelWatcher(w) }
+}
+
+// cancelWatcher removes the given subscription and closes its channel.
+// a writer waiting on the subscription is released first, as it holds the watch lock.
+func (bitcask *Bitcask) cancelWatcher(w *watcher) {
+	w.once.Do(func() {
+		close(w.done)
+
+		bitcask.watchMu.Lock()
+		defer bitcask.watchMu.Unlock()
+
+		delete(bitcask.watchers, w)
+		close(w.events)
+	})
+}
+
+// cancelWatchers cancels all the subscriptions of the bucket.
+func (bitcask *Bitcask) cancelWatchers() {
+	bitcask.watchMu.Lock()
+	watchers := make([]*watcher, 0, len(bitcask.watchers))
+	for w := range bitcask.watchers {
+		watchers = append(watchers, w)
+	}
+	bitcask.watchMu.Unlock()
+
+	for _, w := range watchers {
+		bitcask.cancelWatcher(w)
+	}
+}
+
+// notify emits the events of the given applied writes to the matching subscriptions.
+// the writes of the reserved keys are not emitted.
+// the caller must hold the access lock for writing, so that the events are emitted in the order of the writes.
+func (bitcask *Bitcask) notify(ops []batchOp, tStamp int64) {
+	bitcask.watchMu.Lock()
+	defer bitcask.watchMu.Unlock()
+
+	if len(bitcask.watchers) == 0 {
+		return
+	}
+
+	for _, op := range ops {
+		if isReservedKey(op.key) {
+			continue
+		}
+
+		event := Event{Type: EventPut, Key: append([]byte{}, op.key...), TStamp: tStamp}
+		if op.isDelete {
+			event.Type = EventDelete
+		} else {
+			event.Value = append([]byte{}, op.value...)
+		}
+
+		for w := range bitcask.watchers {
+			if strings.HasPrefix(string(op.key), w.prefix) {
+				w.send(event)
+			}
+		}
+	}
+}
+
+// send delivers the event to the subscription according to its slow consumer policy.
+// the caller must hold the watch lock.
+func (w *watcher) send(event Event) {
+	event.Dropped = w.dropped
+
+	if w.block {
+		select {
+		case w.events <- event:
+		case <-w.done:
+		}
+		return
+	}
+
+	select {
+	case w.events <- event:
+		w.dropped = 0
+	default:
+		w.dropped++
+	}
+}
@@ -0,0 +1,21 @@
+package bitcask
+
+import "testing"
+
+func TestWatchNegativeBuffer(t *testing.T) {
+	bc, err := Open(t.TempDir(), WithReadWrite())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	events, cancel := bc.Watch("", WatchBuffer(-1))
+	defer cancel()
+
+	if cap(events) != 0 {
+		t.Fatalf("got buffer of %d events, want 0", cap(events))
+	}
+	if err := bc.Put("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+}